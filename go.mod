@@ -1,14 +1,18 @@
 module github.com/ipfs-force-community/gosf
 
-go 1.12
+go 1.15
 
 require (
+	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/golang/protobuf v1.3.2
+	github.com/gorilla/websocket v1.4.1
+	github.com/ipfs-force-community/common v0.1.1
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/prometheus/client_golang v1.1.0
 	github.com/stretchr/testify v1.4.0 // indirect
-	github.com/ipfs-force-community/common v0.1.1
 	go.uber.org/atomic v1.4.0 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
 	go.uber.org/zap v1.10.0
+	google.golang.org/genproto v0.0.0-20180817151627-c66870c02cf8
+	google.golang.org/grpc v1.24.0
 )
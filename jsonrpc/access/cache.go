@@ -0,0 +1,67 @@
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ipfs-force-community/common"
+	"github.com/ipfs-force-community/gosf/jsonrpc"
+)
+
+// CacheVaryByPerms shares cached responses among callers with the same perms, instead of the same token,
+// using the fetcher injected by InjectPermsFetcher, which should be in front of jsonrpc.HandleCache
+func CacheVaryByPerms() jsonrpc.CacheVary {
+	return func(req *http.Request) (string, error) {
+		token := req.Header.Get(authorizationHeaderKey)
+		if token == "" {
+			return "", nil
+		}
+
+		fetcher, _ := ExtractPermsFetcher(req)
+		if fetcher == nil {
+			return "", fmt.Errorf("no available access perms fetcher")
+		}
+
+		perms, err := fetcher.Fetch(req.Context(), token)
+		if err != nil || perms == nil {
+			return "", err
+		}
+
+		scopes := make([]string, 0, len(perms.Perms))
+		for scope, perm := range perms.Perms {
+			scopes = append(scopes, fmt.Sprintf("%s=%d", scope, perm))
+		}
+
+		sort.Strings(scopes)
+		return strings.Join(scopes, ";"), nil
+	}
+}
+
+// CacheAuthorizeByPerms checks perms of the caller by the fetcher injected by InjectPermsFetcher, the same way
+// as generated handlers do, so that jsonrpc.HandleCache never serves callers whose perms are revoked or downgraded
+func CacheAuthorizeByPerms() jsonrpc.CacheAuthorize {
+	return func(req *http.Request, scope string, required common.Perm) error {
+		token := req.Header.Get(authorizationHeaderKey)
+		if token == "" {
+			return fmt.Errorf("no access token")
+		}
+
+		fetcher, _ := ExtractPermsFetcher(req)
+		if fetcher == nil {
+			return fmt.Errorf("no available access perms fetcher")
+		}
+
+		perms, err := fetcher.Fetch(req.Context(), token)
+		if err != nil {
+			return err
+		}
+
+		if !CheckPerms(perms, scope, required) {
+			return fmt.Errorf("perm %s of %s required", required, scope)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,39 @@
+package access
+
+import (
+	"net/http"
+
+	"github.com/ipfs-force-community/gosf/jsonrpc"
+)
+
+// RateLimitByAccount keys requests by the account of the Authorization token, or the app for tokens without account,
+// using the fetcher injected by InjectPermsFetcher, which should be in front of jsonrpc.HandleRateLimit.
+// Requests without a valid token get an empty key, use jsonrpc.RateLimitByAny to limit them by other keys
+func RateLimitByAccount() jsonrpc.RateLimitKey {
+	return func(req *http.Request) string {
+		token := req.Header.Get(authorizationHeaderKey)
+		if token == "" {
+			return ""
+		}
+
+		fetcher, _ := ExtractPermsFetcher(req)
+		if fetcher == nil {
+			return ""
+		}
+
+		perms, err := fetcher.Fetch(req.Context(), token)
+		if err != nil || perms == nil {
+			return ""
+		}
+
+		if perms.AccountId != "" {
+			return "account:" + perms.AccountId
+		}
+
+		if perms.AppId != "" {
+			return "app:" + perms.AppId
+		}
+
+		return ""
+	}
+}
@@ -0,0 +1,392 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs-force-community/gosf/proc"
+)
+
+// BalanceStrategy decides which endpoint a call is sent to
+type BalanceStrategy int
+
+// balance strategies
+const (
+	BalanceRoundRobin BalanceStrategy = iota
+	BalanceLeastOutstanding
+
+	// BalanceConsistentHash sends calls with the same BalanceKey to the same endpoint as long as it is available,
+	// calls without a key are balanced in round robin
+	BalanceConsistentHash
+)
+
+const balanceHashReplicas = 100
+
+// BalanceKey sets the key of the call for BalanceConsistentHash
+func BalanceKey(key string) CallOption {
+	return func(co *callOptions) {
+		co.balanceKey = key
+	}
+}
+
+// DefaultBalancerConfig default balancer config
+var DefaultBalancerConfig = BalancerConfig{
+	RefreshInterval:    30 * time.Second,
+	MaxFailures:        3,
+	EjectDuration:      30 * time.Second,
+	HealthCheckPath:    proc.HealthPath,
+	HealthCheckTimeout: time.Second,
+}
+
+// BalancerConfig configures the client created by NewBalancedRPCClient.
+//
+// An endpoint is ejected for EjectDuration after MaxFailures consecutive failures, i.e. transport errors
+// and *RPCError with 5xx codes, and while active health checks fail. If all endpoints are ejected,
+// calls are balanced over all of them rather than failing at once.
+type BalancerConfig struct {
+	Strategy BalanceStrategy
+
+	// RefreshInterval is the interval of resolving endpoints, the previous endpoints are kept if resolving fails
+	RefreshInterval time.Duration
+
+	MaxFailures   int
+	EjectDuration time.Duration
+
+	// HealthCheckInterval enables probing HealthCheckPath of each endpoint with GET, zero disables it
+	HealthCheckInterval time.Duration
+	HealthCheckPath     string
+	HealthCheckTimeout  time.Duration
+
+	// Logger logs endpoint changes & ejections, defaults to zap.S()
+	Logger Logger
+}
+
+// NewBalancedRPCClient creates an rpc client balancing calls over the endpoints resolved by resolver,
+// in place of the host of NewRPCClient. Endpoints are resolved once before returning, and then refreshed
+// & probed in the background until ctx is done.
+func NewBalancedRPCClient(ctx context.Context, resolver Resolver, rt *http.Client, cfg BalancerConfig, opts ...ClientOption) (*RPCClient, error) {
+	def := DefaultBalancerConfig
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = def.RefreshInterval
+	}
+
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = def.MaxFailures
+	}
+
+	if cfg.EjectDuration <= 0 {
+		cfg.EjectDuration = def.EjectDuration
+	}
+
+	if cfg.HealthCheckPath == "" {
+		cfg.HealthCheckPath = def.HealthCheckPath
+	}
+
+	if cfg.HealthCheckTimeout <= 0 {
+		cfg.HealthCheckTimeout = def.HealthCheckTimeout
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = stdLogger
+	}
+
+	rc := NewRPCClient("", rt, opts...)
+	b := &balancer{
+		cfg:      cfg,
+		resolver: resolver,
+		httpcli:  rc.httpcli,
+	}
+
+	if err := b.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("unable to resolve endpoints, err=%v", err)
+	}
+
+	go b.run(ctx)
+
+	rc.balancer = b
+	return rc, nil
+}
+
+type endpoint struct {
+	host string
+
+	// outstanding is accessed atomically
+	outstanding int64
+
+	failures     int
+	ejectedUntil time.Time
+	unhealthy    bool
+}
+
+func (ep *endpoint) available(now time.Time) bool {
+	return !ep.unhealthy && !now.Before(ep.ejectedUntil)
+}
+
+type hashNode struct {
+	hash uint32
+	ep   *endpoint
+}
+
+type balancer struct {
+	cfg      BalancerConfig
+	resolver Resolver
+	httpcli  *http.Client
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	ring      []hashNode
+	next      uint64
+}
+
+func (b *balancer) run(ctx context.Context) {
+	refresh := time.NewTicker(b.cfg.RefreshInterval)
+	defer refresh.Stop()
+
+	var probe <-chan time.Time
+	if b.cfg.HealthCheckInterval > 0 {
+		t := time.NewTicker(b.cfg.HealthCheckInterval)
+		defer t.Stop()
+		probe = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-refresh.C:
+			if err := b.refresh(ctx); err != nil {
+				b.cfg.Logger.Warnf("unable to resolve endpoints, err=%v", err)
+			}
+
+		case <-probe:
+			b.probe(ctx)
+		}
+	}
+}
+
+// refresh replaces the endpoints by the resolved ones, keeping states of the remaining endpoints
+func (b *balancer) refresh(ctx context.Context) error {
+	hosts, err := b.resolver.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(hosts) == 0 {
+		return fmt.Errorf("no endpoint resolved")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing := make(map[string]*endpoint, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		existing[ep.host] = ep
+	}
+
+	endpoints := make([]*endpoint, 0, len(hosts))
+	seen := map[string]bool{}
+	changed := false
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+
+		seen[host] = true
+		ep, ok := existing[host]
+		if !ok {
+			ep = &endpoint{host: host}
+			changed = true
+		}
+
+		endpoints = append(endpoints, ep)
+	}
+
+	if !changed && len(endpoints) == len(b.endpoints) {
+		return nil
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].host < endpoints[j].host
+	})
+
+	b.cfg.Logger.Infof("endpoints changed, from=%v, to=%v", endpointHosts(b.endpoints), endpointHosts(endpoints))
+	b.endpoints = endpoints
+
+	if b.cfg.Strategy == BalanceConsistentHash {
+		ring := make([]hashNode, 0, len(endpoints)*balanceHashReplicas)
+		for _, ep := range endpoints {
+			for i := 0; i < balanceHashReplicas; i++ {
+				ring = append(ring, hashNode{hash: crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + ep.host)), ep: ep})
+			}
+		}
+
+		sort.Slice(ring, func(i, j int) bool {
+			return ring[i].hash < ring[j].hash
+		})
+
+		b.ring = ring
+	}
+
+	return nil
+}
+
+func endpointHosts(endpoints []*endpoint) []string {
+	hosts := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		hosts = append(hosts, ep.host)
+	}
+
+	return hosts
+}
+
+// probe checks the health of all endpoints concurrently
+func (b *balancer) probe(ctx context.Context) {
+	b.mu.Lock()
+	endpoints := b.endpoints
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+
+			healthy := b.healthy(ctx, ep.host)
+
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if ep.unhealthy == !healthy {
+				return
+			}
+
+			ep.unhealthy = !healthy
+			if healthy {
+				b.cfg.Logger.Infof("endpoint passed health check, host=%s", ep.host)
+			} else {
+				b.cfg.Logger.Warnf("endpoint failed health check, host=%s", ep.host)
+			}
+		}(ep)
+	}
+
+	wg.Wait()
+}
+
+func (b *balancer) healthy(ctx context.Context, host string) bool {
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.HealthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, host+b.cfg.HealthCheckPath, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := b.httpcli.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+
+	resp.Body.Close()
+
+	// errors replied by HandleError come with a success status by default
+	return resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices &&
+		resp.Header.Get(ResultCodeHeader) == ""
+}
+
+// pick chooses an endpoint for the call, the returned func must be called with the result of the call
+func (b *balancer) pick(co callOptions) (string, func(error)) {
+	now := time.Now()
+
+	b.mu.Lock()
+	ep := b.choose(co, now)
+	b.mu.Unlock()
+
+	atomic.AddInt64(&ep.outstanding, 1)
+
+	return ep.host, func(err error) {
+		atomic.AddInt64(&ep.outstanding, -1)
+		b.done(ep, err, time.Now())
+	}
+}
+
+func (b *balancer) choose(co callOptions, now time.Time) *endpoint {
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		if ep.available(now) {
+			candidates = append(candidates, ep)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = b.endpoints
+	}
+
+	switch {
+	case b.cfg.Strategy == BalanceConsistentHash && co.balanceKey != "" && len(candidates) < len(b.endpoints):
+		// walk the ring past unavailable endpoints
+		i := b.ringIndex(co.balanceKey)
+		for n := 0; n < len(b.ring); n++ {
+			if ep := b.ring[(i+n)%len(b.ring)].ep; ep.available(now) {
+				return ep
+			}
+		}
+
+	case b.cfg.Strategy == BalanceConsistentHash && co.balanceKey != "":
+		return b.ring[b.ringIndex(co.balanceKey)].ep
+
+	case b.cfg.Strategy == BalanceLeastOutstanding:
+		// start from the round robin position, so that ties are spread
+		start := int(b.next % uint64(len(candidates)))
+		b.next++
+
+		best := candidates[start]
+		for n := 1; n < len(candidates); n++ {
+			ep := candidates[(start+n)%len(candidates)]
+			if atomic.LoadInt64(&ep.outstanding) < atomic.LoadInt64(&best.outstanding) {
+				best = ep
+			}
+		}
+
+		return best
+	}
+
+	ep := candidates[b.next%uint64(len(candidates))]
+	b.next++
+	return ep
+}
+
+func (b *balancer) ringIndex(key string) int {
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= hash
+	})
+
+	return i % len(b.ring)
+}
+
+// done ejects the endpoint after consecutive failures, see circuitOutcomeOf
+func (b *balancer) done(ep *endpoint, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch circuitOutcomeOf(err) {
+	case circuitSuccess:
+		ep.failures = 0
+
+	case circuitFailure:
+		ep.failures++
+		if ep.failures >= b.cfg.MaxFailures {
+			ep.failures = 0
+			ep.ejectedUntil = now.Add(b.cfg.EjectDuration)
+			b.cfg.Logger.Warnf("endpoint ejected, host=%s, duration=%s, err=%v", ep.host, b.cfg.EjectDuration, err)
+		}
+	}
+}
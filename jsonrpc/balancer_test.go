@@ -0,0 +1,165 @@
+package jsonrpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func newBalancerTestServer(t *testing.T, calls *int32, fail *int32) *httptest.Server {
+	mux := NewMux("", nil, HandleError())
+	mux.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		atomic.AddInt32(calls, 1)
+		if atomic.LoadInt32(fail) != 0 {
+			return NewRPCErrorWithCode(http.StatusInternalServerError)
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, "ok")})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBalancedRPCClient(t *testing.T) {
+	var calls, fails [3]int32
+	hosts := make([]string, 3)
+	for i := range hosts {
+		hosts[i] = newBalancerTestServer(t, &calls[i], &fails[i]).URL
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	call := func(cli *RPCClient, opts ...CallOption) error {
+		return cli.Call(ctx, "/Call", common.EMPTY, &common.SimpleResp{}, opts...)
+	}
+
+	reset := func() {
+		for i := range calls {
+			atomic.StoreInt32(&calls[i], 0)
+		}
+	}
+
+	cli, err := NewBalancedRPCClient(ctx, StaticResolver(hosts...), nil, BalancerConfig{MaxFailures: 2, EjectDuration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 30; i++ {
+		if err := call(cli); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range calls {
+		if n := atomic.LoadInt32(&calls[i]); n != 10 {
+			t.Fatalf("expected round robin over endpoints, got %v", calls)
+		}
+	}
+
+	// the failing endpoint is ejected after 2 failures
+	atomic.StoreInt32(&fails[1], 1)
+	reset()
+	failed := 0
+	for i := 0; i < 30; i++ {
+		if err := call(cli); err != nil {
+			failed++
+		}
+	}
+
+	if failed != 2 || atomic.LoadInt32(&calls[1]) != 2 {
+		t.Fatalf("expected the failing endpoint to be ejected, got %d failures, calls %v", failed, calls)
+	}
+
+	// calls with the same key stick to the same endpoint
+	cli, err = NewBalancedRPCClient(ctx, StaticResolver(hosts...), nil, BalancerConfig{Strategy: BalanceConsistentHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&fails[1], 0)
+	reset()
+	for i := 0; i < 10; i++ {
+		if err := call(cli, BalanceKey("user-1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hit := 0
+	for i := range calls {
+		if atomic.LoadInt32(&calls[i]) > 0 {
+			hit++
+		}
+	}
+
+	if hit != 1 {
+		t.Fatalf("expected calls with the same key on a single endpoint, got %v", calls)
+	}
+}
+
+func TestBalancedRPCClientHealthCheck(t *testing.T) {
+	var calls, fails [2]int32
+	hosts := make([]string, 2)
+	for i := range hosts {
+		hosts[i] = newBalancerTestServer(t, &calls[i], &fails[i]).URL
+	}
+
+	var healthy int32 = 1
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/_health" || atomic.LoadInt32(&healthy) == 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer health.Close()
+
+	// the endpoint list is read from a file, the health endpoint being the only one probed successfully
+	path := filepath.Join(t.TempDir(), "endpoints")
+	if err := ioutil.WriteFile(path, []byte("# backends\n"+hosts[0]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cli, err := NewBalancedRPCClient(ctx, FileResolver(path), nil, BalancerConfig{
+		RefreshInterval:     10 * time.Millisecond,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(hosts[0]+"\n"+health.URL+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// hosts[0] has no /_health and gets ejected, the health server has no /Call
+	time.Sleep(100 * time.Millisecond)
+	if err := cli.Call(ctx, "/Call", common.EMPTY, &common.SimpleResp{}); err == nil {
+		t.Fatal("expected calls sent to the only healthy endpoint")
+	}
+
+	if n := atomic.LoadInt32(&calls[0]); n != 0 {
+		t.Fatalf("expected no calls to the unhealthy endpoint, got %d", n)
+	}
+
+	// with all endpoints unhealthy, calls are balanced over all of them
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		cli.Call(ctx, "/Call", common.EMPTY, &common.SimpleResp{})
+	}
+
+	if n := atomic.LoadInt32(&calls[0]); n != 2 {
+		t.Fatalf("expected calls balanced over all endpoints, got %d", n)
+	}
+}
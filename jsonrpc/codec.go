@@ -2,12 +2,25 @@ package jsonrpc
 
 import (
 	"io"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 )
 
+const (
+	contentTypeHeader = "Content-Type"
+	acceptHeader      = "Accept"
+
+	// ContentTypeJSON media type for the json codec
+	ContentTypeJSON = "application/json"
+)
+
 var (
 	jsonMarshaler = jsonpb.Marshaler{
 		EmitDefaults: true,
@@ -23,18 +36,146 @@ var (
 	}
 )
 
-// DecodeRequest decodes given request's body using json format
+// Codec encodes & decodes proto messages in a specific wire format
+type Codec interface {
+	// ContentType returns the media type used in Content-Type & Accept headers
+	ContentType() string
+
+	// Encode writes data into w
+	Encode(w io.Writer, data proto.Message) error
+
+	// Decode reads from r and fills recv
+	Decode(r io.Reader, recv proto.Message) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+
+	// JSONCodec encodes messages using jsonpb
+	JSONCodec Codec = jsonCodec{}
+)
+
+func init() {
+	RegisterCodec(JSONCodec)
+}
+
+// RegisterCodec registers the codec for its content type and the given aliases,
+// replacing any codec previously registered for the same media types
+func RegisterCodec(c Codec, aliases ...string) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for _, ct := range append([]string{c.ContentType()}, aliases...) {
+		codecs[strings.ToLower(ct)] = c
+	}
+}
+
+// LookupCodec returns the codec registered for the given Content-Type or Accept value
+func LookupCodec(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	codecsMu.RLock()
+	c, ok := codecs[mediaType]
+	codecsMu.RUnlock()
+
+	return c, ok
+}
+
+// RequestCodec returns the codec matching the request's Content-Type, or JSONCodec if none matches
+func RequestCodec(req *http.Request) Codec {
+	if c, ok := LookupCodec(req.Header.Get(contentTypeHeader)); ok {
+		return c
+	}
+
+	return JSONCodec
+}
+
+// ResponseCodec returns the codec with the highest preference in the request's Accept header,
+// falls back to RequestCodec when nothing acceptable is registered. Stream requests, whose frames are json,
+// get JSONCodec, e.g. for errors rejecting the stream
+func ResponseCodec(req *http.Request) Codec {
+	for _, mediaType := range parseAccept(req.Header.Get(acceptHeader)) {
+		if c, ok := LookupCodec(mediaType); ok {
+			return c
+		}
+
+		if mediaType == ContentTypeNDJSON || mediaType == ContentTypeEventStream {
+			return JSONCodec
+		}
+	}
+
+	return RequestCodec(req)
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns media types listed in the Accept header ordered by quality,
+// wildcards and ranges with q=0 are dropped
+func parseAccept(accept string) []string {
+	if accept == "" {
+		return nil
+	}
+
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || strings.HasSuffix(mediaType, "/*") {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				q = f
+			}
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	types := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		types = append(types, r.mediaType)
+	}
+
+	return types
+}
+
+// DecodeRequest decodes given request's body using the codec negotiated from Content-Type
 func DecodeRequest(req *http.Request, recv proto.Message) error {
 	defer req.Body.Close()
 
-	return jsonUnmarshaler.Unmarshal(req.Body, recv)
+	return RequestCodec(req).Decode(req.Body, recv)
 }
 
-// EncodeResponse encodes data and write to response body
+// EncodeResponse encodes data and write to response body using json format
 func EncodeResponse(rw http.ResponseWriter, data proto.Message) error {
 	return jsonMarshaler.Marshal(rw, data)
 }
 
+// EncodeResponseFor encodes data using the codec negotiated from the request's Accept header
+func EncodeResponseFor(rw http.ResponseWriter, req *http.Request, data proto.Message) error {
+	c := ResponseCodec(req)
+	rw.Header().Set(contentTypeHeader, c.ContentType())
+
+	return c.Encode(rw, data)
+}
+
 // EncodeJSON encode given data into the writer
 func EncodeJSON(w io.Writer, data proto.Message) error {
 	return jsonMarshaler.Marshal(w, data)
@@ -49,3 +190,17 @@ func DecodeJSON(r io.Reader, recv proto.Message) error {
 func DecodeJSONStrict(r io.Reader, recv proto.Message) error {
 	return jsonStrictUnmarshaler.Unmarshal(r, recv)
 }
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Encode(w io.Writer, data proto.Message) error {
+	return EncodeJSON(w, data)
+}
+
+func (jsonCodec) Decode(r io.Reader, recv proto.Message) error {
+	return DecodeJSON(r, recv)
+}
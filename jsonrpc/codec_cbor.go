@@ -0,0 +1,38 @@
+package jsonrpc
+
+import (
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// ContentTypeCBOR media type for the cbor codec
+const ContentTypeCBOR = "application/cbor"
+
+// CBORCodec encodes messages as cbor maps keyed by the json names of the generated struct fields,
+// oneof fields are not supported
+var CBORCodec Codec = cborCodec{}
+
+func init() {
+	RegisterCodec(CBORCodec)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return ContentTypeCBOR
+}
+
+func (cborCodec) Encode(w io.Writer, data proto.Message) error {
+	return cbor.NewEncoder(w).Encode(data)
+}
+
+func (cborCodec) Decode(r io.Reader, recv proto.Message) error {
+	err := cbor.NewDecoder(r).Decode(recv)
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
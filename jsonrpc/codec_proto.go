@@ -0,0 +1,43 @@
+package jsonrpc
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ContentTypeProtobuf media type for the binary protobuf codec
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// ProtobufCodec encodes messages in the binary protobuf wire format
+var ProtobufCodec Codec = protobufCodec{}
+
+func init() {
+	RegisterCodec(ProtobufCodec, "application/protobuf", "application/vnd.google.protobuf")
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Encode(w io.Writer, data proto.Message) error {
+	b, err := proto.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+func (protobufCodec) Decode(r io.Reader, recv proto.Message) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(b, recv)
+}
@@ -0,0 +1,63 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfs-force-community/common"
+)
+
+func TestResponseCodec(t *testing.T) {
+	cases := []struct {
+		contentType string
+		accept      string
+		expected    Codec
+	}{
+		{"", "", JSONCodec},
+		{"text/plain", "", JSONCodec},
+		{"application/x-protobuf", "", ProtobufCodec},
+		{"application/cbor; charset=binary", "*/*", CBORCodec},
+		{"application/json", "application/x-protobuf", ProtobufCodec},
+		{"application/json", "text/html, application/cbor;q=0.5, application/protobuf;q=0.8", ProtobufCodec},
+		{"application/x-protobuf", "application/xml", ProtobufCodec},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(contentTypeHeader, c.contentType)
+		req.Header.Set(acceptHeader, c.accept)
+
+		if got := ResponseCodec(req); got != c.expected {
+			t.Fatalf("#%d: expected codec %s, got %s", i, c.expected.ContentType(), got.ContentType())
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	in := &common.AccessPerms{
+		AccountId: "account",
+		AppId:     "app",
+		Perms: map[string]common.Perm{
+			"a.b": common.Perm_READ,
+			"c":   common.Perm_BOTH,
+		},
+	}
+
+	for _, c := range []Codec{JSONCodec, ProtobufCodec, CBORCodec} {
+		buf := &bytes.Buffer{}
+		if err := c.Encode(buf, in); err != nil {
+			t.Fatalf("%s: encode: %s", c.ContentType(), err)
+		}
+
+		out := &common.AccessPerms{}
+		if err := c.Decode(buf, out); err != nil {
+			t.Fatalf("%s: decode: %s", c.ContentType(), err)
+		}
+
+		if !proto.Equal(in, out) {
+			t.Fatalf("%s: expected %v, got %v", c.ContentType(), in, out)
+		}
+	}
+}
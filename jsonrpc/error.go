@@ -1,22 +1,169 @@
 package jsonrpc
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/ipfs-force-community/common"
+	"google.golang.org/grpc/codes"
 )
 
 var _ error = (*RPCError)(nil)
 
+// ResultCodeHeader http header carrying the result code of an error response written by HandleError
+const ResultCodeHeader = "X-FORCEUP-RES-CODE"
+
+// ErrorDetailsHeader http header carrying the json encoded details of an error response,
+// for envelopes unable to hold them in the body, e.g. common.SimpleResp
+const ErrorDetailsHeader = "X-FORCEUP-ERR-DETAILS"
+
 // RPCError represents a specific rpc call error
 type RPCError struct {
 	Code int
 	Msg  string
+
+	// ReqID is the request id reported by the server, only set on the client side
+	ReqID string
+
+	// Reason is a machine-readable reason of the error, e.g. "ORDER_NOT_FOUND"
+	Reason string
+
+	// FieldViolations describes invalid fields of the request
+	FieldViolations []FieldViolation
+
+	// RetryAfter tells the client how long to wait before retrying, zero means unset
+	RetryAfter time.Duration
+
+	// Details carries arbitrary proto messages
+	Details []*any.Any
+
+	// GRPCCode is the grpc code of the error, zero means derived from Code by CodeFromHTTPStatus
+	GRPCCode codes.Code
+}
+
+// FieldViolation describes a single invalid field of a request
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
 }
 
 func (r *RPCError) Error() string {
+	if r.ReqID != "" {
+		return fmt.Sprintf("json rpc error: code=%d, msg=%s, req_id=%s", r.Code, r.Msg, r.ReqID)
+	}
+
 	return fmt.Sprintf("json rpc error: code=%d, msg=%s", r.Code, r.Msg)
 }
 
+// WithReason sets the machine-readable reason and returns the error itself
+func (r *RPCError) WithReason(reason string) *RPCError {
+	r.Reason = reason
+	return r
+}
+
+// WithFieldViolation appends a field violation and returns the error itself
+func (r *RPCError) WithFieldViolation(field, description string) *RPCError {
+	r.FieldViolations = append(r.FieldViolations, FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+
+	return r
+}
+
+// WithRetryAfter sets the retry hint and returns the error itself
+func (r *RPCError) WithRetryAfter(d time.Duration) *RPCError {
+	r.RetryAfter = d
+	return r
+}
+
+// WithDetails packs given messages into Any details and returns the error itself
+func (r *RPCError) WithDetails(msgs ...proto.Message) (*RPCError, error) {
+	for _, msg := range msgs {
+		a, err := ptypes.MarshalAny(msg)
+		if err != nil {
+			return r, err
+		}
+
+		r.Details = append(r.Details, a)
+	}
+
+	return r, nil
+}
+
+// HTTPStatus returns the http status matching the error code, codes out of the http status range map to 500
+func (r *RPCError) HTTPStatus() int {
+	if r.Code >= http.StatusBadRequest && r.Code < 600 {
+		return r.Code
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (r *RPCError) hasDetails() bool {
+	return r.Reason != "" || len(r.FieldViolations) > 0 || r.RetryAfter > 0 || len(r.Details) > 0 || r.GRPCCode != codes.OK
+}
+
+// errorDetails is the json form of the details of an *RPCError
+type errorDetails struct {
+	Reason          string            `json:"reason,omitempty"`
+	FieldViolations []FieldViolation  `json:"field_violations,omitempty"`
+	RetryAfter      string            `json:"retry_after,omitempty"`
+	Details         []json.RawMessage `json:"details,omitempty"`
+	GRPCCode        uint32            `json:"grpc_code,omitempty"`
+}
+
+func (r *RPCError) details() *errorDetails {
+	d := &errorDetails{
+		Reason:          r.Reason,
+		FieldViolations: r.FieldViolations,
+		GRPCCode:        uint32(r.GRPCCode),
+	}
+
+	if r.RetryAfter > 0 {
+		d.RetryAfter = r.RetryAfter.String()
+	}
+
+	// Any of unregistered types can not be encoded into json, thus dropped
+	for _, a := range r.Details {
+		buf := bytes.NewBufferString("")
+		if err := EncodeJSON(buf, a); err == nil {
+			d.Details = append(d.Details, buf.Bytes())
+		}
+	}
+
+	return d
+}
+
+func (r *RPCError) setDetails(d *errorDetails) {
+	r.Reason = d.Reason
+	r.FieldViolations = d.FieldViolations
+	r.GRPCCode = codes.Code(d.GRPCCode)
+
+	if d.RetryAfter != "" {
+		r.RetryAfter, _ = time.ParseDuration(d.RetryAfter)
+	}
+
+	for _, raw := range d.Details {
+		a := &any.Any{}
+		if err := DecodeJSON(bytes.NewReader(raw), a); err == nil {
+			r.Details = append(r.Details, a)
+		}
+	}
+}
+
+func newSimpleResp(e *RPCError) *common.SimpleResp {
+	return &common.SimpleResp{
+		Res: common.NewResult(int32(e.Code), e.Msg),
+	}
+}
+
 // NewRPCErrorWithCode returns a *RPCError wraps the given http StatusCode
 func NewRPCErrorWithCode(code int, msg ...string) *RPCError {
 	e := &RPCError{
@@ -33,3 +180,8 @@ func NewRPCErrorWithCode(code int, msg ...string) *RPCError {
 
 	return e
 }
+
+// IsSuccessCode reports whether the given result code means success, zero is treated as unset
+func IsSuccessCode(code int) bool {
+	return code == 0 || (code >= http.StatusOK && code < http.StatusMultipleChoices)
+}
@@ -0,0 +1,88 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentTypeProblemJSON media type for RFC 7807 problem details
+const ContentTypeProblemJSON = "application/problem+json"
+
+// ErrorFormat writes the reply for an error captured by HandleError, with the given http status
+type ErrorFormat func(rw http.ResponseWriter, req *http.Request, status int, e *RPCError) error
+
+// errorData is the json object holding request id & details of an error in problem+json and json-rpc 2.0 envelopes
+type errorData struct {
+	ReqID string `json:"req_id,omitempty"`
+	*errorDetails
+}
+
+func newErrorData(e *RPCError, reqID string) *errorData {
+	if !e.hasDetails() && reqID == "" {
+		return nil
+	}
+
+	return &errorData{
+		ReqID:        reqID,
+		errorDetails: e.details(),
+	}
+}
+
+// SimpleRespErrorFormat writes a common.SimpleResp with the negotiated codec, details are carried by ErrorDetailsHeader
+func SimpleRespErrorFormat(rw http.ResponseWriter, req *http.Request, status int, e *RPCError) error {
+	if e.hasDetails() {
+		b, err := json.Marshal(e.details())
+		if err != nil {
+			return err
+		}
+
+		rw.Header().Set(ErrorDetailsHeader, string(b))
+	}
+
+	c := ResponseCodec(req)
+	rw.Header().Set(contentTypeHeader, c.ContentType())
+	rw.WriteHeader(status)
+
+	return c.Encode(rw, newSimpleResp(e))
+}
+
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     int    `json:"code"`
+	*errorData
+}
+
+// ProblemJSONErrorFormat writes RFC 7807 problem details, with code, req_id & details as extension members
+func ProblemJSONErrorFormat(rw http.ResponseWriter, req *http.Request, status int, e *RPCError) error {
+	p := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    e.Msg,
+		Instance:  req.URL.Path,
+		Code:      e.Code,
+		errorData: newErrorData(e, rw.Header().Get(RequestIDHeader)),
+	}
+
+	rw.Header().Set(contentTypeHeader, ContentTypeProblemJSON)
+	rw.WriteHeader(status)
+
+	return json.NewEncoder(rw).Encode(p)
+}
+
+// JSONRPC2ErrorFormat writes a JSON-RPC 2.0 error response with a null id
+func JSONRPC2ErrorFormat(rw http.ResponseWriter, req *http.Request, status int, e *RPCError) error {
+	resp := newJSONRPC2ErrorResponse(nil, e.Code, e.Msg)
+	if data := newErrorData(e, rw.Header().Get(RequestIDHeader)); data != nil {
+		resp.Error.Data = data
+	}
+
+	rw.Header().Set(contentTypeHeader, ContentTypeJSON)
+	rw.WriteHeader(status)
+
+	return json.NewEncoder(rw).Encode(resp)
+}
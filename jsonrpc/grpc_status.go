@@ -0,0 +1,199 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusClientClosedRequest is the non-standard status used by nginx for requests canceled by the client
+const statusClientClosedRequest = 499
+
+var codeToHTTPStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           statusClientClosedRequest,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+var httpStatusToCode = map[int]codes.Code{
+	http.StatusOK:                  codes.OK,
+	statusClientClosedRequest:      codes.Canceled,
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusMethodNotAllowed:    codes.Unimplemented,
+	http.StatusRequestTimeout:      codes.DeadlineExceeded,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusPreconditionFailed:  codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusInternalServerError: codes.Internal,
+	http.StatusNotImplemented:      codes.Unimplemented,
+	http.StatusBadGateway:          codes.Unavailable,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+}
+
+// HTTPStatusFromCode returns the http status for the given grpc code
+func HTTPStatusFromCode(c codes.Code) int {
+	if s, ok := codeToHTTPStatus[c]; ok {
+		return s
+	}
+
+	return http.StatusInternalServerError
+}
+
+// CodeFromHTTPStatus returns the grpc code for the given http status,
+// RPCError.Code of the http status range is converted with the same table
+func CodeFromHTTPStatus(s int) codes.Code {
+	if c, ok := httpStatusToCode[s]; ok {
+		return c
+	}
+
+	switch {
+	case s >= http.StatusOK && s < http.StatusMultipleChoices:
+		return codes.OK
+
+	case s >= http.StatusBadRequest && s < http.StatusInternalServerError:
+		return codes.FailedPrecondition
+
+	case s >= http.StatusInternalServerError && s < 600:
+		return codes.Internal
+	}
+
+	return codes.Unknown
+}
+
+// ToRPCError converts any error into an *RPCError, errors carrying a grpc status are converted by RPCErrorFromStatus,
+// context errors are converted into 504 & 499, and others are treated as internal errors
+func ToRPCError(err error) *RPCError {
+	if e, ok := err.(*RPCError); ok {
+		return e
+	}
+
+	switch err {
+	case context.DeadlineExceeded:
+		return newDeadlineExceededError(0)
+
+	case context.Canceled:
+		return NewRPCErrorWithCode(statusClientClosedRequest, "request canceled")
+	}
+
+	if s, ok := status.FromError(err); ok {
+		return RPCErrorFromStatus(s)
+	}
+
+	return &RPCError{
+		Code: http.StatusInternalServerError,
+		Msg:  err.Error(),
+	}
+}
+
+// RPCErrorFromStatus converts a grpc status, the code is mapped by HTTPStatusFromCode and kept in GRPCCode,
+// BadRequest, RetryInfo & RequestInfo details are unpacked into the matching fields
+func RPCErrorFromStatus(s *status.Status) *RPCError {
+	e := &RPCError{
+		Code:     HTTPStatusFromCode(s.Code()),
+		Msg:      s.Message(),
+		GRPCCode: s.Code(),
+	}
+
+	for _, a := range s.Proto().GetDetails() {
+		switch {
+		case ptypes.Is(a, (*errdetails.BadRequest)(nil)):
+			br := &errdetails.BadRequest{}
+			if ptypes.UnmarshalAny(a, br) == nil {
+				for _, v := range br.GetFieldViolations() {
+					e.WithFieldViolation(v.GetField(), v.GetDescription())
+				}
+			}
+
+		case ptypes.Is(a, (*errdetails.RetryInfo)(nil)):
+			ri := &errdetails.RetryInfo{}
+			if ptypes.UnmarshalAny(a, ri) == nil && ri.GetRetryDelay() != nil {
+				e.RetryAfter, _ = ptypes.Duration(ri.GetRetryDelay())
+			}
+
+		case ptypes.Is(a, (*errdetails.RequestInfo)(nil)):
+			info := &errdetails.RequestInfo{}
+			if ptypes.UnmarshalAny(a, info) == nil {
+				e.ReqID = info.GetRequestId()
+			}
+
+		default:
+			e.Details = append(e.Details, a)
+		}
+	}
+
+	return e
+}
+
+// GRPCStatus implements the interface recognized by status.FromError, so that an *RPCError returned by
+// a service method is reported with the matching code by grpc servers as well.
+// Reason is not represented since errdetails.ErrorInfo is not available
+func (r *RPCError) GRPCStatus() *status.Status {
+	code := r.GRPCCode
+	if code == codes.OK {
+		code = CodeFromHTTPStatus(r.Code)
+	}
+
+	if code == codes.OK {
+		code = codes.Unknown
+	}
+
+	details := []*any.Any{}
+
+	if len(r.FieldViolations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range r.FieldViolations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+
+		if a, err := ptypes.MarshalAny(br); err == nil {
+			details = append(details, a)
+		}
+	}
+
+	if r.RetryAfter > 0 {
+		if a, err := ptypes.MarshalAny(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(r.RetryAfter)}); err == nil {
+			details = append(details, a)
+		}
+	}
+
+	if r.ReqID != "" {
+		if a, err := ptypes.MarshalAny(&errdetails.RequestInfo{RequestId: r.ReqID}); err == nil {
+			details = append(details, a)
+		}
+	}
+
+	details = append(details, r.Details...)
+
+	return status.FromProto(&spb.Status{
+		Code:    int32(code),
+		Message: r.Msg,
+		Details: details,
+	})
+}
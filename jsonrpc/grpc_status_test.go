@@ -0,0 +1,99 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ipfs-force-community/common"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCStatusError(t *testing.T) {
+	mux := NewMux("", nil, InjectRequestID(), HandleError(WithHTTPStatus()))
+	mux.Handle("/NotFound", func(rw http.ResponseWriter, req *http.Request) error {
+		return status.Error(codes.NotFound, "no such order")
+	})
+
+	mux.Handle("/Unavailable", func(rw http.ResponseWriter, req *http.Request) error {
+		s, err := status.New(codes.Unavailable, "overloaded").WithDetails(
+			&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(2 * time.Second)},
+			&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "size", Description: "too large"}}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s.Err()
+	})
+
+	mux.Handle("/Aborted", func(rw http.ResponseWriter, req *http.Request) error {
+		return status.Error(codes.Aborted, "conflict")
+	})
+
+	stdmux := http.NewServeMux()
+	mux.register(stdmux)
+
+	srv := httptest.NewServer(stdmux)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/NotFound", ContentTypeJSON, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+
+	cli := NewRPCClient(srv.URL, nil)
+	err = cli.Call(context.Background(), "/Unavailable", common.EMPTY, &common.SimpleResp{})
+	e, ok := err.(*RPCError)
+	if !ok {
+		t.Fatalf("expected *RPCError, got %v", err)
+	}
+
+	if e.Code != http.StatusServiceUnavailable || e.GRPCCode != codes.Unavailable || e.RetryAfter != 2*time.Second ||
+		len(e.FieldViolations) != 1 || e.FieldViolations[0].Field != "size" {
+		t.Fatalf("unexpected error %#v", e)
+	}
+
+	cli = NewRPCClient(srv.URL, nil, WithGRPCStatusErrors())
+	for method, code := range map[string]codes.Code{
+		"/NotFound":    codes.NotFound,
+		"/Unavailable": codes.Unavailable,
+		"/Aborted":     codes.Aborted,
+	} {
+		err := cli.Call(context.Background(), method, common.EMPTY, &common.SimpleResp{})
+		s, ok := status.FromError(err)
+		if !ok {
+			t.Fatalf("%s: expected status error, got %v", method, err)
+		}
+
+		if s.Code() != code {
+			t.Fatalf("%s: expected code %s, got %s", method, code, s.Code())
+		}
+
+		if method == "/Unavailable" {
+			e := RPCErrorFromStatus(s)
+			if e.RetryAfter != 2*time.Second || e.ReqID == "" {
+				t.Fatalf("%s: details lost, got %#v", method, e)
+			}
+		}
+	}
+}
+
+func TestCodeMapping(t *testing.T) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		s := HTTPStatusFromCode(c)
+		if got := NewRPCErrorWithCode(s).GRPCStatus().Code(); c != codes.OK && HTTPStatusFromCode(got) != s {
+			t.Fatalf("%s: http status %d maps back to %s", c, s, got)
+		}
+	}
+}
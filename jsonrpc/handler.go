@@ -1,13 +1,43 @@
 package jsonrpc
 
 import (
+	"bytes"
+	"context"
 	"net/http"
+
+	"github.com/ipfs-force-community/common"
 )
 
 type patternedHandler struct {
+	method  string
 	pattern string
 	handler HandlerFunc
+	scope   string
+	perm    common.Perm
+	cache   *CacheControl
 }
 
 // HandlerFunc is like http.HandlerFunc, but returns an error
 type HandlerFunc = func(rw http.ResponseWriter, req *http.Request) error
+
+// newDispatchedRequest builds the request for a call carried by another request, e.g. a json-rpc 2.0 envelope,
+// params are encoded as json and errors are passed through HandleError to the transport
+func newDispatchedRequest(ctx context.Context, outer *http.Request, pattern string, params []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, pattern, bytes.NewReader(params))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req = Inject(req, ctxKeyRawError, true)
+	req.RequestURI = pattern
+	req.RemoteAddr = outer.RemoteAddr
+	req.Host = outer.Host
+	for k, v := range outer.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set(contentTypeHeader, ContentTypeJSON)
+	req.Header.Set(acceptHeader, ContentTypeJSON)
+
+	return req, nil
+}
@@ -0,0 +1,391 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/status"
+)
+
+// JSON-RPC 2.0 error codes reserved by the specification
+const (
+	JSONRPC2ParseError     = -32700
+	JSONRPC2InvalidRequest = -32600
+	JSONRPC2MethodNotFound = -32601
+	JSONRPC2InvalidParams  = -32602
+	JSONRPC2InternalError  = -32603
+)
+
+const (
+	jsonrpc2Version = "2.0"
+
+	defaultJSONRPC2MaxBatchSize     = 100
+	defaultJSONRPC2BatchConcurrency = 8
+)
+
+var (
+	// JSONRPC2MaxBatchSize is the max number of calls in a batch, larger batches are rejected as invalid requests,
+	// non-positive values mean 100
+	JSONRPC2MaxBatchSize = defaultJSONRPC2MaxBatchSize
+
+	// JSONRPC2BatchConcurrency is the max number of calls of a batch running at the same time, non-positive values mean 8
+	JSONRPC2BatchConcurrency = defaultJSONRPC2BatchConcurrency
+)
+
+var ctxKeyJSONRPC2 = NewCtxKey("_jsonrpc2")
+
+// JSONRPC2Error is the error object of a JSON-RPC 2.0 response
+type JSONRPC2Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type jsonrpc2Request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// notification reports whether the request carries no id, thus expects no response
+func (r *jsonrpc2Request) notification() bool {
+	return r.ID == nil
+}
+
+type jsonrpc2Response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPC2Error  `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func newJSONRPC2ErrorResponse(id json.RawMessage, code int, msg string) *jsonrpc2Response {
+	return &jsonrpc2Response{
+		Version: jsonrpc2Version,
+		Error: &JSONRPC2Error{
+			Code:    code,
+			Message: msg,
+		},
+		ID: id,
+	}
+}
+
+// IsJSONRPC2Call reports whether the request is dispatched by the JSON-RPC 2.0 endpoint
+func IsJSONRPC2Call(req *http.Request) bool {
+	is, _ := Extract(req, ctxKeyJSONRPC2).(bool)
+	return is
+}
+
+// RegisterJSONRPC2 registers a JSON-RPC 2.0 endpoint for all handlers of jmux onto the given std *http.ServeMux,
+// and uses http.DefaultServeMux if stdmux is nil
+func RegisterJSONRPC2(stdmux *http.ServeMux, pattern string, jmux *Mux, mds ...Middleware) {
+	if stdmux == nil {
+		stdmux = http.DefaultServeMux
+	}
+
+	stdmux.Handle(pattern, jmux.JSONRPC2Handler(mds...))
+}
+
+// JSONRPC2Handler returns a single endpoint accepting JSON-RPC 2.0 requests, notifications & batches.
+// The method of a call is a path matching a registered handler as POST, with or without the leading slash,
+// e.g. "v1/Svc/Method". Each call runs through the whole middleware chain of its handler,
+// while the given mds only wrap the endpoint itself, e.g. HandleCORS for browser callers.
+// Calls of a batch run concurrently, bounded by JSONRPC2BatchConcurrency, and batches are limited by JSONRPC2MaxBatchSize.
+func (m *Mux) JSONRPC2Handler(mds ...Middleware) http.Handler {
+	h := &jsonrpc2Handler{
+		mux: m,
+	}
+
+	var hdl HandlerFunc = h.serve
+	for size := len(mds); size > 0; size-- {
+		hdl = mds[size-1](hdl)
+	}
+
+	return route{
+		pattern: "",
+		handler: hdl,
+		logger:  m.logger,
+	}.httpHandler()
+}
+
+type jsonrpc2Handler struct {
+	mux *Mux
+}
+
+func (h *jsonrpc2Handler) serve(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '[' {
+		return h.serveBatch(rw, req, body)
+	}
+
+	var resp *jsonrpc2Response
+
+	r := &jsonrpc2Request{}
+	if err := json.Unmarshal(body, r); err != nil {
+		resp = newJSONRPC2ErrorResponse(nil, JSONRPC2ParseError, err.Error())
+	} else {
+		resp = h.call(rw, req, r)
+	}
+
+	if resp == nil {
+		rw.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return writeJSONRPC2(rw, resp)
+}
+
+func (h *jsonrpc2Handler) serveBatch(rw http.ResponseWriter, req *http.Request, body []byte) error {
+	raws := []json.RawMessage{}
+	if err := json.Unmarshal(body, &raws); err != nil {
+		return writeJSONRPC2(rw, newJSONRPC2ErrorResponse(nil, JSONRPC2ParseError, err.Error()))
+	}
+
+	if len(raws) == 0 {
+		return writeJSONRPC2(rw, newJSONRPC2ErrorResponse(nil, JSONRPC2InvalidRequest, "empty batch"))
+	}
+
+	maxSize := JSONRPC2MaxBatchSize
+	if maxSize <= 0 {
+		maxSize = defaultJSONRPC2MaxBatchSize
+	}
+
+	if len(raws) > maxSize {
+		return writeJSONRPC2(rw, newJSONRPC2ErrorResponse(nil, JSONRPC2InvalidRequest, fmt.Sprintf("batch exceeds %d calls", maxSize)))
+	}
+
+	concurrency := JSONRPC2BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultJSONRPC2BatchConcurrency
+	}
+
+	resps := make([]*jsonrpc2Response, len(raws))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var headerMu sync.Mutex
+
+	for i := range raws {
+		r := &jsonrpc2Request{}
+		if err := json.Unmarshal(raws[i], r); err != nil {
+			resps[i] = newJSONRPC2ErrorResponse(nil, JSONRPC2InvalidRequest, err.Error())
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			buf := newResponseBuffer()
+			resps[i] = h.call(buf, req, r)
+
+			headerMu.Lock()
+			copyMissingHeaders(rw.Header(), buf.Header())
+			headerMu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+
+	out := make([]*jsonrpc2Response, 0, len(resps))
+	for _, resp := range resps {
+		if resp != nil {
+			out = append(out, resp)
+		}
+	}
+
+	if len(out) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	rw.Header().Set(contentTypeHeader, ContentTypeJSON)
+	return json.NewEncoder(rw).Encode(out)
+}
+
+// call dispatches a single request to the matching handler, headers set by the handler are copied into rw,
+// returns nil for notifications
+func (h *jsonrpc2Handler) call(rw http.ResponseWriter, outer *http.Request, r *jsonrpc2Request) *jsonrpc2Response {
+	if r.Version != jsonrpc2Version || r.Method == "" {
+		return newJSONRPC2ErrorResponse(r.ID, JSONRPC2InvalidRequest, "invalid json-rpc 2.0 request")
+	}
+
+	resp := h.invoke(rw, outer, r)
+	if r.notification() {
+		return nil
+	}
+
+	return resp
+}
+
+func (h *jsonrpc2Handler) invoke(rw http.ResponseWriter, outer *http.Request, r *jsonrpc2Request) *jsonrpc2Response {
+	pattern := r.Method
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+
+	rt, pathParams, err := h.mux.router().match(http.MethodPost, pattern)
+	if err != nil {
+		return newJSONRPC2ErrorResponse(r.ID, JSONRPC2MethodNotFound, "method not found: "+r.Method)
+	}
+
+	params, ok := jsonrpc2Params(r.Params)
+	if !ok {
+		return newJSONRPC2ErrorResponse(r.ID, JSONRPC2InvalidParams, "params should be an object or an array with a single object")
+	}
+
+	req, err := newDispatchedRequest(outer.Context(), outer, pattern, params)
+	if err != nil {
+		return newJSONRPC2ErrorResponse(r.ID, JSONRPC2InternalError, err.Error())
+	}
+
+	req = Inject(req, ctxKeyJSONRPC2, true)
+	if pathParams != nil {
+		req = Inject(req, ctxKeyPathParams, pathParams)
+	}
+
+	buf := newResponseBuffer()
+	err = rt.handler(buf, req)
+
+	copyMissingHeaders(rw.Header(), buf.Header())
+
+	if err == nil && buf.status() >= http.StatusBadRequest {
+		err = NewRPCErrorWithCode(buf.status())
+	}
+
+	result := json.RawMessage(bytes.TrimSpace(buf.body.Bytes()))
+
+	// messages of server-streaming methods are collected into an array
+	if err == nil && buf.Header().Get(contentTypeHeader) == ContentTypeNDJSON {
+		result, err = collectNDJSON(result)
+	}
+
+	if err != nil {
+		var e *RPCError
+		if _, ok := status.FromError(err); ok {
+			e = ToRPCError(err)
+		} else {
+			e = &RPCError{
+				Code: JSONRPC2InternalError,
+				Msg:  err.Error(),
+			}
+		}
+
+		resp := newJSONRPC2ErrorResponse(r.ID, e.Code, e.Msg)
+		if data := newErrorData(e, buf.Header().Get(RequestIDHeader)); data != nil {
+			resp.Error.Data = data
+		}
+
+		return resp
+	}
+
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+
+	return &jsonrpc2Response{
+		Version: jsonrpc2Version,
+		Result:  result,
+		ID:      r.ID,
+	}
+}
+
+// collectNDJSON converts frames written by a *ServerStream into a json array of messages
+func collectNDJSON(body []byte) (json.RawMessage, error) {
+	msgs := []json.RawMessage{}
+
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		frame := streamFrame{}
+		if err := json.Unmarshal(line, &frame); err != nil {
+			return nil, err
+		}
+
+		if frame.Error != nil {
+			return nil, &RPCError{
+				Code: int(frame.Error.Code),
+				Msg:  frame.Error.Msg,
+			}
+		}
+
+		if frame.End {
+			break
+		}
+
+		msgs = append(msgs, frame.Result)
+	}
+
+	return json.Marshal(msgs)
+}
+
+// jsonrpc2Params converts params into the json object expected by handlers
+func jsonrpc2Params(raw json.RawMessage) ([]byte, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return []byte("{}"), true
+	}
+
+	switch raw[0] {
+	case '{':
+		return raw, true
+
+	case '[':
+		arr := []json.RawMessage{}
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return nil, false
+		}
+
+		if len(arr) == 0 {
+			return []byte("{}"), true
+		}
+
+		if len(arr) == 1 && bytes.HasPrefix(bytes.TrimSpace(arr[0]), []byte("{")) {
+			return bytes.TrimSpace(arr[0]), true
+		}
+	}
+
+	return nil, false
+}
+
+func writeJSONRPC2(rw http.ResponseWriter, resp *jsonrpc2Response) error {
+	rw.Header().Set(contentTypeHeader, ContentTypeJSON)
+	return json.NewEncoder(rw).Encode(resp)
+}
+
+// copyMissingHeaders copies headers in src absent from dst, except the ones describing the body
+func copyMissingHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if k == contentTypeHeader || k == "Content-Length" {
+			continue
+		}
+
+		if _, ok := dst[k]; !ok {
+			dst[k] = v
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func newJSONRPC2TestMux() *Mux {
+	mux := NewMux("/v1", nil, HandleError(), HandlePanic())
+	mux.Handle("/Echo", func(rw http.ResponseWriter, req *http.Request) error {
+		in := &common.Result{}
+		if err := DecodeRequest(req, in); err != nil {
+			return err
+		}
+
+		return EncodeResponseFor(rw, req, in)
+	})
+
+	mux.Handle("/Fail", func(rw http.ResponseWriter, req *http.Request) error {
+		return NewRPCErrorWithCode(http.StatusNotFound, "not found")
+	})
+
+	return mux
+}
+
+func TestJSONRPC2Handler(t *testing.T) {
+	hdl := newJSONRPC2TestMux().JSONRPC2Handler()
+
+	cases := []struct {
+		body     string
+		status   int
+		expected string
+	}{
+		{
+			`{"jsonrpc":"2.0","method":"v1/Echo","params":{"code":1,"msg":"a"},"id":1}`,
+			http.StatusOK,
+			`{"jsonrpc":"2.0","result":{"code":1,"msg":"a"},"id":1}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"/v1/Echo","params":[{"code":2}],"id":"x"}`,
+			http.StatusOK,
+			`{"jsonrpc":"2.0","result":{"code":2,"msg":""},"id":"x"}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"v1/Fail","id":null}`,
+			http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":404,"message":"not found"},"id":null}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"v1/Missing","id":3}`,
+			http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: v1/Missing"},"id":3}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"v1/Echo","params":{}}`,
+			http.StatusNoContent,
+			``,
+		},
+		{
+			`[{"jsonrpc":"2.0","method":"v1/Echo","params":{"code":1},"id":1},{"jsonrpc":"2.0","method":"v1/Echo"},1]`,
+			http.StatusOK,
+			`[{"jsonrpc":"2.0","result":{"code":1,"msg":""},"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type jsonrpc.jsonrpc2Request"},"id":null}]`,
+		},
+		{
+			`[]`,
+			http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(c.body))
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+
+		if rec.Code != c.status {
+			t.Fatalf("#%d: expected status %d, got %d", i, c.status, rec.Code)
+		}
+
+		if c.expected == "" {
+			continue
+		}
+
+		if got := strings.TrimSpace(rec.Body.String()); !jsonEqual(t, got, c.expected) {
+			t.Fatalf("#%d: expected %s, got %s", i, c.expected, got)
+		}
+	}
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("invalid json %s: %s", a, err)
+	}
+
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("invalid json %s: %s", b, err)
+	}
+
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+func TestJSONRPC2BatchLimits(t *testing.T) {
+	var running, peak int32
+	mux := NewMux("/v1", nil)
+	mux.Handle("/Slow", func(rw http.ResponseWriter, req *http.Request) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	hdl := mux.JSONRPC2Handler()
+	batch := func(n int) *httptest.ResponseRecorder {
+		calls := make([]string, n)
+		for i := range calls {
+			calls[i] = `{"jsonrpc":"2.0","method":"v1/Slow","id":1}`
+		}
+
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader("["+strings.Join(calls, ",")+"]")))
+		return rec
+	}
+
+	resps := []jsonrpc2Response{}
+	if err := json.Unmarshal(batch(JSONRPC2MaxBatchSize).Body.Bytes(), &resps); err != nil || len(resps) != JSONRPC2MaxBatchSize {
+		t.Fatalf("expected all calls replied, got %d, err=%v", len(resps), err)
+	}
+
+	if peak > int32(JSONRPC2BatchConcurrency) {
+		t.Fatalf("expected at most %d concurrent calls, got %d", JSONRPC2BatchConcurrency, peak)
+	}
+
+	resp := jsonrpc2Response{}
+	if err := json.Unmarshal(batch(JSONRPC2MaxBatchSize+1).Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Code != JSONRPC2InvalidRequest {
+		t.Fatalf("expected oversized batch rejected, got %+v, err=%v", resp, err)
+	}
+}
@@ -2,16 +2,59 @@ package jsonrpc
 
 import (
 	"fmt"
+	"math"
 	"net/http"
-
-	"github.com/ipfs-force-community/common"
+	"strconv"
 )
 
+const retryAfterHeader = "Retry-After"
+
 // Middleware defines a simple middleware for jsonrpc
 type Middleware func(HandlerFunc) HandlerFunc
 
+// transports building error replies by themselves, e.g. json-rpc 2.0 & websocket, inject ctxKeyRawError
+// so that HandleError passes errors through
+var ctxKeyRawError = NewCtxKey("_raw_error")
+
+func rawErrorWanted(req *http.Request) bool {
+	raw, _ := Extract(req, ctxKeyRawError).(bool)
+	return raw
+}
+
+type errorOptions struct {
+	httpStatus bool
+	format     ErrorFormat
+}
+
+// ErrorOption configures HandleError
+type ErrorOption func(*errorOptions)
+
+// WithHTTPStatus makes HandleError reply with the http status matching the error code instead of 200
+func WithHTTPStatus() ErrorOption {
+	return func(opts *errorOptions) {
+		opts.httpStatus = true
+	}
+}
+
+// WithErrorFormat sets the envelope of error replies, defaults to SimpleRespErrorFormat
+func WithErrorFormat(f ErrorFormat) ErrorOption {
+	return func(opts *errorOptions) {
+		if f != nil {
+			opts.format = f
+		}
+	}
+}
+
 // HandleError wraps inner HandlerFunc with error handler
-func HandleError() Middleware {
+func HandleError(opts ...ErrorOption) Middleware {
+	options := errorOptions{
+		format: SimpleRespErrorFormat,
+	}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return func(inner HandlerFunc) HandlerFunc {
 
 		return func(rw http.ResponseWriter, req *http.Request) error {
@@ -20,19 +63,25 @@ func HandleError() Middleware {
 				return nil
 			}
 
-			resp := common.SimpleResp{}
+			if rawErrorWanted(req) {
+				return err
+			}
 
-			switch e := err.(type) {
-			case *RPCError:
-				resp.Res = common.NewResult(int32(e.Code), e.Msg)
+			e := ToRPCError(err)
 
-			default:
-				resp.Res = common.NewResult(int32(http.StatusInternalServerError), e.Error())
+			status := http.StatusOK
+			if options.httpStatus {
+				status = e.HTTPStatus()
+			}
 
+			header := rw.Header()
+			header.Set(ResultCodeHeader, strconv.Itoa(e.Code))
+			if e.RetryAfter > 0 {
+				header.Set(retryAfterHeader, strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
 			}
 
-			if err := EncodeResponse(rw, &resp); err != nil {
-				RequestLogger(req).Errorf("error occurs during encoding captured inner err, req_id=%s, resp=%v", RequestID(req), resp)
+			if err := options.format(rw, req, status, e); err != nil {
+				RequestLogger(req).Errorf("error occurs during encoding captured inner err, req_id=%s, err=%v, cause=%v", RequestID(req), e, err)
 			}
 
 			return nil
@@ -60,6 +109,11 @@ func HandlePanic() Middleware {
 							Msg:  fmt.Sprintf("recover from internal panic, req_id=%q", reqID),
 						}
 					}
+
+					// streams already started are terminated with an error frame, instead of a response appended to them
+					if isStreamResponse(rw.Header()) {
+						err = resumeServerStream(rw, req).Finish(err)
+					}
 				}
 			}()
 
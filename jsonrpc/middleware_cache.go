@@ -0,0 +1,377 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+	"github.com/ipfs-force-community/gosf/metric"
+	"github.com/ipfs-force-community/gosf/proc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	etagHeader         = "ETag"
+	ifNoneMatchHeader  = "If-None-Match"
+	cacheControlHeader = "Cache-Control"
+
+	// CacheStatusHeader http header telling whether the response is served from the cache, HIT or MISS
+	CacheStatusHeader = "X-FORCEUP-CACHE"
+
+	defaultCacheMaxEntries  = 10000
+	defaultCacheMaxBodySize = 64 << 10
+)
+
+func init() {
+	metric.Collect(cacheRequestsMetric)
+}
+
+var cacheRequestsMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "service",
+		Subsystem: proc.AppName(),
+		Name:      "cache_requests_total",
+	},
+	[]string{"route", "result"},
+)
+
+// CacheControl declares how responses of a route are cached by HandleCache,
+// generated codes set it from the gosf.cache_control method option
+type CacheControl struct {
+	// MaxAge is the time to live of cached responses, zero falls back to CacheConfig.DefaultMaxAge
+	MaxAge time.Duration
+
+	// NoStore disables caching of the route
+	NoStore bool
+}
+
+// WithCacheControl declares the cache control of the handler, see HandleCache
+func WithCacheControl(cc CacheControl) RouteOption {
+	return func(h *patternedHandler) {
+		h.cache = &cc
+	}
+}
+
+// CachedResponse is a response kept by a CacheStore, with the headers set by the handlers it wraps
+type CachedResponse struct {
+	Header  http.Header
+	Body    []byte
+	ETag    string
+	Expires time.Time
+}
+
+// CacheStore keeps cached responses, entries must not be returned after they expire
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+}
+
+// CacheVary returns the part of the cache key identifying the caller of routes requiring perms,
+// requests with an error bypass the cache
+type CacheVary func(req *http.Request) (string, error)
+
+// CacheVaryByToken shares cached responses among requests with the same Authorization token
+func CacheVaryByToken() CacheVary {
+	return func(req *http.Request) (string, error) {
+		return req.Header.Get(authorizationHeader), nil
+	}
+}
+
+// CacheAuthorize checks whether the caller has the perm of the scope required by a route,
+// before a cached response is served, see HandleCache
+type CacheAuthorize func(req *http.Request, scope string, required common.Perm) error
+
+// CacheConfig configures HandleCache
+type CacheConfig struct {
+	// Store defaults to an in-memory LRU store
+	Store CacheStore
+
+	// DefaultMaxAge applies to routes requiring READ perm without a MaxAge of their own, given Authorize,
+	// zero means only routes declaring a MaxAge are cached
+	DefaultMaxAge time.Duration
+
+	// Vary defaults to CacheVaryByToken, access.CacheVaryByPerms shares responses among callers with the same perms
+	Vary CacheVary
+
+	// Authorize checks perms of callers of routes requiring perms, e.g. access.CacheAuthorizeByPerms,
+	// those routes are not cached without it
+	Authorize CacheAuthorize
+
+	// MaxBodySize bounds the size of request bodies worth caching
+	MaxBodySize int64
+}
+
+// HandleCache caches successful responses of routes requiring READ perm or no perm at all, keyed by the route,
+// the canonicalized request body & the caller of routes requiring perms. Responses carry an ETag,
+// and requests with a matching If-None-Match get a 304 without body. Streaming responses & errors are not cached.
+//
+// Perms are checked inside handlers, which are skipped by cache hits, so routes requiring perms are only cached
+// with CacheConfig.Authorize, which checks the perms of the caller before anything is served from the cache,
+// and responses are only shared among callers distinguished by CacheConfig.Vary.
+func HandleCache(cfg CacheConfig) Middleware {
+	if cfg.Store == nil {
+		cfg.Store = NewLRUCacheStore(defaultCacheMaxEntries)
+	}
+
+	if cfg.Vary == nil {
+		cfg.Vary = CacheVaryByToken()
+	}
+
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultCacheMaxBodySize
+	}
+
+	return func(inner HandlerFunc) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			meta := extractRouteMeta(req)
+			maxAge := cacheMaxAge(meta, cfg.DefaultMaxAge)
+			if maxAge <= 0 || (req.Method != http.MethodPost && req.Method != http.MethodGet) {
+				return inner(rw, req)
+			}
+
+			// callers without the perm are replied by the handler, e.g. those with revoked tokens
+			if meta.perm != common.Perm_NONE && (cfg.Authorize == nil || cfg.Authorize(req, meta.scope, meta.perm) != nil) {
+				return inner(rw, req)
+			}
+
+			req, key, ok := cacheKey(req, meta, cfg)
+			if !ok {
+				return inner(rw, req)
+			}
+
+			cacheControl := "max-age=" + strconv.Itoa(int(maxAge/time.Second))
+			if meta.perm != common.Perm_NONE {
+				cacheControl = "private, " + cacheControl
+			}
+
+			if cached, ok := cfg.Store.Get(key); ok {
+				cacheRequestsMetric.WithLabelValues(meta.pattern, "hit").Inc()
+
+				header := rw.Header()
+				replayHeader(header, cached.Header)
+				header.Set(cacheControlHeader, cacheControl)
+				header.Set(CacheStatusHeader, "HIT")
+				writeCachedResponse(rw, req, cached)
+				return nil
+			}
+
+			cacheRequestsMetric.WithLabelValues(meta.pattern, "miss").Inc()
+
+			cw := newBufferingWriter(rw, func(code int, header http.Header) bool {
+				return code == http.StatusOK && header.Get(ResultCodeHeader) == "" && !isStreamResponse(header)
+			})
+
+			if err := inner(cw, req); err != nil || !cw.buffering {
+				return err
+			}
+
+			sum := sha256.Sum256(cw.body.Bytes())
+			cached := &CachedResponse{
+				Header:  cw.writtenHeader(),
+				Body:    cw.body.Bytes(),
+				ETag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+				Expires: time.Now().Add(maxAge),
+			}
+
+			// headers bound to a single request are not replayed
+			cached.Header.Del(RequestIDHeader)
+
+			header := rw.Header()
+			header.Set(cacheControlHeader, cacheControl)
+			header.Set(CacheStatusHeader, "MISS")
+
+			cfg.Store.Set(key, cached)
+			writeCachedResponse(rw, req, cached)
+			return nil
+		}
+	}
+}
+
+// cacheMaxAge returns the time to live of responses of the route, non-positive values mean not cacheable
+func cacheMaxAge(meta *routeMeta, def time.Duration) time.Duration {
+	if meta == nil || meta.perm&common.Perm_WRITE != 0 {
+		return 0
+	}
+
+	if meta.cache != nil {
+		if meta.cache.NoStore {
+			return 0
+		}
+
+		if meta.cache.MaxAge > 0 {
+			return meta.cache.MaxAge
+		}
+	}
+
+	if meta.perm == common.Perm_READ {
+		return def
+	}
+
+	return 0
+}
+
+// cacheKey reads the request body, and returns the request with the body restored along with the key
+func cacheKey(req *http.Request, meta *routeMeta, cfg CacheConfig) (*http.Request, string, bool) {
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(io.LimitReader(req.Body, cfg.MaxBodySize+1))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+
+		if err != nil || int64(len(b)) > cfg.MaxBodySize {
+			return req, "", false
+		}
+
+		body = b
+	}
+
+	var vary string
+	if meta.perm != common.Perm_NONE {
+		v, err := cfg.Vary(req)
+		if err != nil {
+			RequestLogger(req).Warnf("unable to get the cache vary of request, err=%v", err)
+			return req, "", false
+		}
+
+		vary = v
+	}
+
+	h := sha256.New()
+	for _, part := range []string{
+		meta.pattern,
+		req.URL.Path,
+		req.URL.RawQuery,
+		req.Header.Get(acceptHeader),
+		req.Header.Get(contentTypeHeader),
+		vary,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	h.Write(canonicalBody(req.Header.Get(contentTypeHeader), body))
+	return req, hex.EncodeToString(h.Sum(nil)), true
+}
+
+// canonicalBody re-encodes json bodies with sorted keys & no spaces, so that equal requests share the key
+func canonicalBody(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if len(body) == 0 || (mediaType != "" && mediaType != ContentTypeJSON) {
+		return body
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+
+	return b
+}
+
+// writeCachedResponse writes the body of cached, or a 304 if the request has a matching If-None-Match
+func writeCachedResponse(rw http.ResponseWriter, req *http.Request, cached *CachedResponse) {
+	rw.Header().Set(etagHeader, cached.ETag)
+
+	if etagMatch(req.Header.Get(ifNoneMatchHeader), cached.ETag) {
+		rw.Header().Del(contentTypeHeader)
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(cached.Body)
+}
+
+// etagMatch compares etags weakly as If-None-Match requires
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewLRUCacheStore returns an in-memory CacheStore holding at most maxEntries responses,
+// evicting the least recently used ones
+func NewLRUCacheStore(maxEntries int) CacheStore {
+	return &lruCacheStore{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+type lruCacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+type lruCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+func (s *lruCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*lruCacheEntry)
+	if !time.Now().Before(entry.resp.Expires) {
+		s.order.Remove(elem)
+		delete(s.entries, key)
+		return nil, false
+	}
+
+	s.order.MoveToFront(elem)
+	return entry.resp, true
+}
+
+func (s *lruCacheStore) Set(key string, resp *CachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		elem.Value.(*lruCacheEntry).resp = resp
+		s.order.MoveToFront(elem)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&lruCacheEntry{key: key, resp: resp})
+
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruCacheEntry).key)
+	}
+}
@@ -0,0 +1,202 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestHandleCache(t *testing.T) {
+	calls := map[string]int{}
+	handler := func(rw http.ResponseWriter, req *http.Request) error {
+		calls[RoutePattern(req)]++
+
+		in := &common.SimpleResp{}
+		if err := DecodeRequest(req, in); err != nil {
+			return err
+		}
+
+		if in.Res.GetCode() != 0 {
+			return NewRPCErrorWithCode(http.StatusNotFound)
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, in.Res.GetMsg())})
+	}
+
+	revoked := map[string]bool{}
+	authorize := func(req *http.Request, scope string, required common.Perm) error {
+		if revoked[req.Header.Get(authorizationHeader)] {
+			return NewRPCErrorWithCode(http.StatusForbidden)
+		}
+
+		return nil
+	}
+
+	mux := NewMux("/v1", nil, InjectRequestID(), HandleError(), HandleCache(CacheConfig{DefaultMaxAge: time.Minute, Authorize: authorize}))
+	mux.Handle("/Get", handler, WithAccess("demo", common.Perm_READ))
+	mux.Handle("/Public", handler, WithCacheControl(CacheControl{MaxAge: time.Second}))
+	mux.Handle("/NoStore", handler, WithAccess("demo", common.Perm_READ), WithCacheControl(CacheControl{NoStore: true}))
+	mux.Handle("/Create", handler, WithAccess("demo", common.Perm_WRITE), WithCacheControl(CacheControl{MaxAge: time.Second}))
+
+	call := func(path, token, body, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(contentTypeHeader, ContentTypeJSON)
+		if token != "" {
+			req.Header.Set(authorizationHeader, token)
+		}
+
+		if etag != "" {
+			req.Header.Set(ifNoneMatchHeader, etag)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := call("/v1/Get", "a", `{"res": {"msg": "x", "code": 0}}`, "")
+	if first.Header().Get(CacheStatusHeader) != "MISS" || first.Header().Get(etagHeader) == "" {
+		t.Fatalf("expected cache miss with etag, got %v", first.Header())
+	}
+
+	if cc := first.Header().Get(cacheControlHeader); cc != "private, max-age=60" {
+		t.Fatalf("unexpected cache control %q", cc)
+	}
+
+	// equal json bodies share the key
+	second := call("/v1/Get", "a", `{"res":{"code":0,"msg":"x"}}`, "")
+	if second.Header().Get(CacheStatusHeader) != "HIT" || second.Body.String() != first.Body.String() || calls["/v1/Get"] != 1 {
+		t.Fatalf("expected cache hit, got %v %q", second.Header(), second.Body.String())
+	}
+
+	if second.Header().Get(RequestIDHeader) == first.Header().Get(RequestIDHeader) {
+		t.Fatal("expected request id not replayed")
+	}
+
+	if rec := call("/v1/Get", "a", `{"res":{"code":0,"msg":"x"}}`, first.Header().Get(etagHeader)); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// callers losing the perm are not served from the cache
+	revoked["a"] = true
+	if rec := call("/v1/Get", "a", `{"res":{"code":0,"msg":"x"}}`, ""); rec.Header().Get(CacheStatusHeader) != "" || calls["/v1/Get"] != 2 {
+		t.Fatalf("expected the cache bypassed for a revoked token, got %v", rec.Header())
+	}
+
+	revoked["a"] = false
+	calls["/v1/Get"] = 1
+
+	// callers with other tokens do not share responses
+	if rec := call("/v1/Get", "b", `{"res":{"code":0,"msg":"x"}}`, ""); rec.Header().Get(CacheStatusHeader) != "MISS" || calls["/v1/Get"] != 2 {
+		t.Fatalf("expected cache miss for another token, got %v", rec.Header())
+	}
+
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		if rec := call("/v1/Get", "a", `{"res":{"code":1}}`, ""); rec.Header().Get(ResultCodeHeader) != "404" {
+			t.Fatalf("expected error, got %v", rec.Header())
+		}
+	}
+
+	if calls["/v1/Get"] != 4 {
+		t.Fatalf("expected errors not cached, got %d calls", calls["/v1/Get"])
+	}
+
+	for _, path := range []string{"/v1/Public", "/v1/NoStore", "/v1/Create"} {
+		for i := 0; i < 2; i++ {
+			call(path, "", `{}`, "")
+		}
+	}
+
+	if calls["/v1/Public"] != 1 || calls["/v1/NoStore"] != 2 || calls["/v1/Create"] != 2 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestLRUCacheStore(t *testing.T) {
+	store := NewLRUCacheStore(2)
+	expires := time.Now().Add(time.Minute)
+
+	store.Set("a", &CachedResponse{Expires: expires})
+	store.Set("b", &CachedResponse{Expires: expires})
+	store.Get("a")
+	store.Set("c", &CachedResponse{Expires: expires})
+
+	if _, ok := store.Get("b"); ok {
+		t.Fatal("expected the least recently used entry evicted")
+	}
+
+	store.Set("d", &CachedResponse{Expires: time.Now().Add(-time.Second)})
+	for key, want := range map[string]bool{"a": false, "c": true, "d": false} {
+		if _, ok := store.Get(key); ok != want {
+			t.Fatalf("%s: expected %v, got %v", key, want, ok)
+		}
+	}
+}
+
+func TestHandleCacheWithoutAuthorize(t *testing.T) {
+	calls := 0
+	mux := NewMux("/v1", nil, HandleCache(CacheConfig{DefaultMaxAge: time.Minute}))
+	mux.Handle("/Get", func(rw http.ResponseWriter, req *http.Request) error {
+		calls++
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	}, WithAccess("demo", common.Perm_READ), WithCacheControl(CacheControl{MaxAge: time.Minute}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Get", strings.NewReader(`{}`))
+		req.Header.Set(authorizationHeader, "a")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected routes requiring perms not cached without Authorize, got %d calls", calls)
+	}
+}
+
+func TestHandleCacheOuterHeaders(t *testing.T) {
+	mux := NewMux("/v1", nil,
+		mustCORSPolicy(t, CORSPolicy{AllowedOrigins: []string{"https://a.com", "https://b.com"}}),
+		InjectRequestID(),
+		HandleCache(CacheConfig{}),
+	)
+	mux.Handle("/Get", func(rw http.ResponseWriter, req *http.Request) error {
+		rw.Header().Set("X-Custom", "1")
+		addVary(rw.Header(), "Accept-Language")
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	}, WithCacheControl(CacheControl{MaxAge: time.Minute}))
+
+	call := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Get", strings.NewReader(`{}`))
+		req.Header.Set(contentTypeHeader, ContentTypeJSON)
+		req.Header.Set(originHeader, origin)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := call("https://a.com")
+	if first.Header().Get(CacheStatusHeader) != "MISS" || first.Header().Get(corsHeaderAllowOrigin) != "https://a.com" {
+		t.Fatalf("unexpected headers %v", first.Header())
+	}
+
+	for origin, allow := range map[string]string{"https://b.com": "https://b.com", "https://evil.com": ""} {
+		rec := call(origin)
+		header := rec.Header()
+		if header.Get(CacheStatusHeader) != "HIT" || header.Get(corsHeaderAllowOrigin) != allow {
+			t.Fatalf("%s: expected the cors grant of the current request, got %v", origin, header)
+		}
+
+		if header.Get(RequestIDHeader) == "" || header.Get(RequestIDHeader) == first.Header().Get(RequestIDHeader) {
+			t.Fatalf("%s: expected the request id of the current request, got %v", origin, header)
+		}
+
+		if header.Get("X-Custom") != "1" || strings.Join(header[varyHeader], ", ") != "Origin, Accept-Language" {
+			t.Fatalf("%s: expected headers of the handler replayed, got %v", origin, header)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package jsonrpc
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs-force-community/gosf/metric"
+	"github.com/ipfs-force-community/gosf/proc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReasonOverloaded is the reason of errors replied by HandleConcurrencyLimit
+const ReasonOverloaded = "OVERLOADED"
+
+func init() {
+	metric.Collect(concurrencyLimitMetric, concurrencyInflightMetric, concurrencyRejectedMetric)
+}
+
+var (
+	concurrencyLabels = []string{"limiter", "route"}
+
+	concurrencyLimitMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "service",
+			Subsystem: proc.AppName(),
+			Name:      "concurrency_limit",
+		},
+		concurrencyLabels,
+	)
+
+	concurrencyInflightMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "service",
+			Subsystem: proc.AppName(),
+			Name:      "concurrency_inflight",
+		},
+		concurrencyLabels,
+	)
+
+	concurrencyRejectedMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "service",
+			Subsystem: proc.AppName(),
+			Name:      "concurrency_rejected_total",
+		},
+		concurrencyLabels,
+	)
+)
+
+// DefaultConcurrencyLimitConfig default concurrency limit config
+var DefaultConcurrencyLimitConfig = ConcurrencyLimitConfig{
+	Name:          "default",
+	InitialLimit:  100,
+	MinLimit:      4,
+	MaxLimit:      1000,
+	LatencyTarget: time.Second,
+	Backoff:       0.9,
+}
+
+// ConcurrencyLimitConfig configures HandleConcurrencyLimit
+type ConcurrencyLimitConfig struct {
+	// Name labels the metrics of the limiter
+	Name string
+
+	// PerMethod gives each route its own limit, instead of one limit shared by all routes wrapped by the middleware
+	PerMethod bool
+
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// LatencyTarget is the latency above which a request is treated as a sign of overload
+	LatencyTarget time.Duration
+
+	// Backoff is the ratio the limit is multiplied by on overload, in (0, 1)
+	Backoff float64
+}
+
+// HandleConcurrencyLimit caps in-flight requests, and rejects excess ones at once with a 503 *RPCError
+// rather than queueing them. The limit adapts in AIMD style: it grows by one per window of requests
+// finished within LatencyTarget while the limit is in use, and shrinks by Backoff on requests exceeding
+// LatencyTarget or their deadlines, at most once per round of requests: requests started before the last
+// shrink do not shrink the limit again, so a burst of slow requests backs off only once.
+// Streaming responses count as in-flight but do not adjust the limit.
+//
+// The current limit, in-flight requests & rejections are exported through the metric package.
+func HandleConcurrencyLimit(cfg ConcurrencyLimitConfig) Middleware {
+	def := DefaultConcurrencyLimitConfig
+	if cfg.Name == "" {
+		cfg.Name = def.Name
+	}
+
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = def.MinLimit
+	}
+
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = def.MaxLimit
+	}
+
+	if cfg.MaxLimit < cfg.MinLimit {
+		cfg.MaxLimit = cfg.MinLimit
+	}
+
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = def.InitialLimit
+	}
+
+	cfg.InitialLimit = int(math.Max(float64(cfg.MinLimit), math.Min(float64(cfg.MaxLimit), float64(cfg.InitialLimit))))
+
+	if cfg.LatencyTarget <= 0 {
+		cfg.LatencyTarget = def.LatencyTarget
+	}
+
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = def.Backoff
+	}
+
+	var (
+		mu       sync.Mutex
+		limiters = map[string]*concurrencyLimiter{}
+	)
+
+	limiterFor := func(route string) *concurrencyLimiter {
+		mu.Lock()
+		defer mu.Unlock()
+
+		l, ok := limiters[route]
+		if !ok {
+			l = newConcurrencyLimiter(cfg, route)
+			limiters[route] = l
+		}
+
+		return l
+	}
+
+	return func(inner HandlerFunc) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			route := ""
+			if cfg.PerMethod {
+				route = RoutePattern(req)
+			}
+
+			l := limiterFor(route)
+			if !l.acquire() {
+				return NewRPCErrorWithCode(http.StatusServiceUnavailable, "too many concurrent requests").
+					WithReason(ReasonOverloaded)
+			}
+
+			start := time.Now()
+			err := inner(rw, req)
+
+			streamed := false
+			switch rw.Header().Get(contentTypeHeader) {
+			case ContentTypeNDJSON, ContentTypeEventStream:
+				streamed = true
+			}
+
+			l.release(start, time.Now(), streamed, req.Context().Err() == context.DeadlineExceeded)
+			return err
+		}
+	}
+}
+
+type concurrencyLimiter struct {
+	cfg ConcurrencyLimitConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+
+	// backoffAt is when the limit shrank last time
+	backoffAt time.Time
+
+	limitGauge    prometheus.Gauge
+	inflightGauge prometheus.Gauge
+	rejected      prometheus.Counter
+}
+
+func newConcurrencyLimiter(cfg ConcurrencyLimitConfig, route string) *concurrencyLimiter {
+	labels := prometheus.Labels{"limiter": cfg.Name, "route": route}
+
+	l := &concurrencyLimiter{
+		cfg:           cfg,
+		limit:         float64(cfg.InitialLimit),
+		limitGauge:    concurrencyLimitMetric.With(labels),
+		inflightGauge: concurrencyInflightMetric.With(labels),
+		rejected:      concurrencyRejectedMetric.With(labels),
+	}
+
+	l.limitGauge.Set(l.limit)
+	return l
+}
+
+func (l *concurrencyLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= int(l.limit) {
+		l.rejected.Inc()
+		return false
+	}
+
+	l.inflight++
+	l.inflightGauge.Set(float64(l.inflight))
+	return true
+}
+
+// release ends a request started at start
+func (l *concurrencyLimiter) release(start, now time.Time, streamed, deadlineExceeded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the limit only grows while it is actually in use, otherwise it would grow unbounded during low traffic
+	inUse := float64(l.inflight) >= l.limit/2
+
+	l.inflight--
+	l.inflightGauge.Set(float64(l.inflight))
+
+	if streamed {
+		return
+	}
+
+	switch {
+	case deadlineExceeded || now.Sub(start) > l.cfg.LatencyTarget:
+		// the overload is already accounted by the last shrink
+		if start.Before(l.backoffAt) {
+			return
+		}
+
+		l.backoffAt = now
+		l.limit = math.Max(float64(l.cfg.MinLimit), l.limit*l.cfg.Backoff)
+
+	case inUse:
+		l.limit = math.Min(float64(l.cfg.MaxLimit), l.limit+1/l.limit)
+
+	default:
+		return
+	}
+
+	l.limitGauge.Set(l.limit)
+}
+
+// current returns the current limit
+func (l *concurrencyLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
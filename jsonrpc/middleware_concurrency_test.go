@@ -0,0 +1,137 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandleConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+
+	mux := NewMux("", nil, HandleError(WithHTTPStatus()), HandleConcurrencyLimit(ConcurrencyLimitConfig{
+		Name:         "test",
+		InitialLimit: 2,
+		MinLimit:     1,
+		MaxLimit:     2,
+	}))
+	mux.Handle("/Block", func(rw http.ResponseWriter, req *http.Request) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+
+	call := func() int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/Block", nil))
+		return rec.Code
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call()
+		}()
+	}
+
+	<-started
+	<-started
+
+	if code := call(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected excess request shed with 503, got %d", code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("expected request allowed after release, got %d", code)
+	}
+}
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	cfg := DefaultConcurrencyLimitConfig
+	cfg.Name = "test_aimd"
+	cfg.InitialLimit = 10
+	cfg.MinLimit = 2
+	cfg.MaxLimit = 20
+	cfg.LatencyTarget = 100 * time.Millisecond
+
+	l := newConcurrencyLimiter(cfg, "")
+
+	// slow requests in successive rounds shrink the limit down to MinLimit
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		l.acquire()
+		l.release(now, now.Add(time.Second), false, false)
+		now = now.Add(time.Second)
+	}
+
+	if got := l.current(); got != cfg.MinLimit {
+		t.Fatalf("expected limit %d, got %d", cfg.MinLimit, got)
+	}
+
+	// fast requests under load grow it again
+	for i := 0; i < 100; i++ {
+		l.acquire()
+		l.acquire()
+		l.release(now, now.Add(time.Millisecond), false, false)
+		l.release(now, now.Add(time.Millisecond), false, false)
+		now = now.Add(time.Millisecond)
+	}
+
+	if got := l.current(); got <= cfg.MinLimit {
+		t.Fatalf("expected limit grown above %d, got %d", cfg.MinLimit, got)
+	}
+
+	// streams never adjust the limit
+	before := l.current()
+	l.acquire()
+	l.release(now, now.Add(time.Hour), true, false)
+	if got := l.current(); got != before {
+		t.Fatalf("expected limit %d unchanged by streams, got %d", before, got)
+	}
+}
+
+func TestConcurrencyLimiterBurst(t *testing.T) {
+	cfg := DefaultConcurrencyLimitConfig
+	cfg.Name = "test_burst"
+	cfg.InitialLimit = 100
+	cfg.MinLimit = 2
+	cfg.LatencyTarget = 100 * time.Millisecond
+
+	l := newConcurrencyLimiter(cfg, "")
+
+	// a burst of slow requests in flight together backs off once
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		if !l.acquire() {
+			t.Fatal("expected request allowed")
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.release(start, start.Add(time.Second+time.Duration(i)*time.Millisecond), false, false)
+		}(i)
+	}
+
+	wg.Wait()
+
+	if got := l.current(); got != 90 {
+		t.Fatalf("expected limit 90 after a single backoff, got %d", got)
+	}
+
+	// requests started after the backoff may shrink it again
+	l.acquire()
+	l.release(start.Add(2*time.Second), start.Add(3*time.Second), false, false)
+	if got := l.current(); got != 81 {
+		t.Fatalf("expected limit 81 after the second backoff, got %d", got)
+	}
+}
@@ -1,19 +1,33 @@
 package jsonrpc
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	corsHeaderAllowOrigin  = "Access-Control-Allow-Origin"
-	corsHeaderAllowMethods = "Access-Control-Allow-Methods"
-	corsHeaderAllowHeaders = "Access-Control-Allow-Headers"
+	corsHeaderAllowOrigin      = "Access-Control-Allow-Origin"
+	corsHeaderAllowMethods     = "Access-Control-Allow-Methods"
+	corsHeaderAllowHeaders     = "Access-Control-Allow-Headers"
+	corsHeaderAllowCredentials = "Access-Control-Allow-Credentials"
+	corsHeaderExposeHeaders    = "Access-Control-Expose-Headers"
+	corsHeaderMaxAge           = "Access-Control-Max-Age"
+	corsHeaderRequestMethod    = "Access-Control-Request-Method"
+	corsHeaderRequestHeaders   = "Access-Control-Request-Headers"
+
+	originHeader = "Origin"
+	varyHeader   = "Vary"
 
 	corsAllowedHadersBase = "Keep-Alive, User-Agent, Content-Type, Authorization"
 )
 
 var (
+	corsMu sync.RWMutex
+
 	customizeCORSHeaders = []string{RequestIDHeader}
 
 	corsAllowedHaders = strings.Join(
@@ -26,32 +40,303 @@ var (
 	)
 )
 
-// AddCustomizeCORSHeader 添加自定义 CORS 头
+// AddCustomizeCORSHeader 添加自定义 CORS 头, 作用于 HandleCORS & ApplyCORSHeaders
 func AddCustomizeCORSHeader(header ...string) {
+	corsMu.Lock()
+	defer corsMu.Unlock()
+
 	customizeCORSHeaders = append(customizeCORSHeaders, header...)
 	corsAllowedHaders = strings.Join(append([]string{corsAllowedHadersBase}, customizeCORSHeaders...), ", ")
 }
 
 // ApplyCORSHeaders add cors headers to the given rw
 func ApplyCORSHeaders(header http.Header) {
+	corsMu.RLock()
+	allowed := corsAllowedHaders
+	corsMu.RUnlock()
+
 	header.Set(corsHeaderAllowOrigin, "*")
 	header.Set(corsHeaderAllowMethods, "OPTIONS, GET, POST")
-	header.Set(corsHeaderAllowHeaders, corsAllowedHaders)
+	header.Set(corsHeaderAllowHeaders, allowed)
+}
+
+// DefaultCORSPolicy is the policy of HandleCORS, allowing any origin without credentials
+var DefaultCORSPolicy = CORSPolicy{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{http.MethodOptions, http.MethodGet, http.MethodPost},
+	ExposedHeaders: []string{RequestIDHeader},
+}
+
+// CORSPolicy configures HandleCORSPolicy
+type CORSPolicy struct {
+	// AllowedOrigins are origins allowed to call, "*" allows any origin, and "https://*.example.com"
+	// any subdomain of example.com over https. Empty means no cross-origin calls are allowed
+	AllowedOrigins []string
+
+	// AllowedMethods defaults to OPTIONS, GET & POST
+	AllowedMethods []string
+
+	// AllowedHeaders are request headers allowed besides Keep-Alive, User-Agent, Content-Type, Authorization,
+	// RequestIDHeader, TimeoutHeader, IdempotencyKeyHeader & those added by AddCustomizeCORSHeader
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers readable by scripts, e.g. RequestIDHeader
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies or http authentication, which requires AllowedOrigins
+	// listed explicitly or by subdomain patterns, HandleCORSPolicy fails if they contain "*"
+	AllowCredentials bool
+
+	// MaxAge is how long preflight results can be cached by browsers, zero leaves it to browsers
+	MaxAge time.Duration
 }
 
-// HandleCORS handles cors OPTIONS requests, and set required headers for any other request
+// HandleCORS handles cors preflight requests, and sets required headers for any other request, by DefaultCORSPolicy
 func HandleCORS() Middleware {
 	return func(inner HandlerFunc) HandlerFunc {
+		corsMu.RLock()
+		policy := DefaultCORSPolicy
+		policy.AllowedHeaders = append([]string(nil), customizeCORSHeaders...)
+		corsMu.RUnlock()
 
+		mw, err := HandleCORSPolicy(policy)
+		if err != nil {
+			// DefaultCORSPolicy is modified to allow credentials with any origin
+			policy.AllowCredentials = false
+			mw, _ = HandleCORSPolicy(policy)
+		}
+
+		return mw(inner)
+	}
+}
+
+// HandleCORSPolicy handles cors preflight requests, and sets required headers for cross-origin requests
+// allowed by the given policy, along with Vary: Origin. Requests without Origin only get the headers
+// if the policy allows any origin.
+//
+// OPTIONS requests are always answered by the policy, without calling the handlers.
+//
+// Muxes may override the policy of their ancestors by their own HandleCORSPolicy, which takes effect
+// for preflight requests as well, as the mux routes them through the middlewares of the route of the requested method.
+func HandleCORSPolicy(p CORSPolicy) (Middleware, error) {
+	c, err := newCORS(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(inner HandlerFunc) HandlerFunc {
 		return func(rw http.ResponseWriter, req *http.Request) error {
-			ApplyCORSHeaders(rw.Header())
+			if isCORSPreflight(req) {
+				// the mux replies the preflight after all middlewares of the route, the innermost policy wins
+				if state, ok := Extract(req, ctxKeyCORSPreflight).(*corsPreflight); ok {
+					state.cors = c
+					return inner(rw, req)
+				}
+
+				c.replyPreflight(rw, req)
+				return nil
+			}
 
+			origin := req.Header.Get(originHeader)
+			c.apply(rw.Header(), origin)
 			if req.Method == http.MethodOptions {
+				if c.originAllowed(origin) {
+					rw.Header().Set(corsHeaderAllowMethods, c.methods)
+				}
+
 				rw.WriteHeader(http.StatusOK)
 				return nil
 			}
 
 			return inner(rw, req)
 		}
+	}, nil
+}
+
+var ctxKeyCORSPreflight = NewCtxKey("_cors_preflight")
+
+// corsPreflight collects the policy of a preflight request routed by the mux
+type corsPreflight struct {
+	cors *cors
+}
+
+func isCORSPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get(originHeader) != "" && req.Header.Get(corsHeaderRequestMethod) != ""
+}
+
+// replyPreflight is the innermost handler of preflight requests routed by the mux
+func replyPreflight(rw http.ResponseWriter, req *http.Request) error {
+	state, _ := Extract(req, ctxKeyCORSPreflight).(*corsPreflight)
+	if state == nil || state.cors == nil {
+		rw.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+
+	state.cors.replyPreflight(rw, req)
+	return nil
+}
+
+type cors struct {
+	policy CORSPolicy
+
+	anyOrigin bool
+	origins   map[string]bool
+
+	// wildcard origins split around the "*"
+	wildcards [][2]string
+
+	allowedMethods map[string]bool
+	allowedHeaders map[string]bool
+	methods        string
+	exposed        string
+	maxAge         string
+}
+
+func newCORS(p CORSPolicy) (*cors, error) {
+	c := &cors{
+		policy:         p,
+		origins:        map[string]bool{},
+		allowedMethods: map[string]bool{},
+		allowedHeaders: map[string]bool{},
+		exposed:        strings.Join(p.ExposedHeaders, ", "),
+	}
+
+	for _, origin := range p.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+
+		case strings.Contains(origin, "*"):
+			i := strings.Index(origin, "*")
+			c.wildcards = append(c.wildcards, [2]string{origin[:i], origin[i+1:]})
+
+		default:
+			c.origins[origin] = true
+		}
+	}
+
+	if c.anyOrigin && p.AllowCredentials {
+		return nil, errors.New("cors policy allowing any origin must not allow credentials")
+	}
+
+	methods := p.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSPolicy.AllowedMethods
+	}
+
+	for _, m := range methods {
+		c.allowedMethods[strings.ToUpper(m)] = true
+	}
+
+	c.methods = strings.Join(methods, ", ")
+
+	headers := append(strings.Split(corsAllowedHadersBase, ", "), RequestIDHeader, TimeoutHeader, IdempotencyKeyHeader)
+	for _, h := range append(headers, p.AllowedHeaders...) {
+		c.allowedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+
+	if p.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(p.MaxAge / time.Second))
+	}
+
+	return c, nil
+}
+
+func (c *cors) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+
+	for _, w := range c.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) &&
+			!strings.ContainsAny(origin[len(w[0]):len(origin)-len(w[1])], "/:") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply sets the headers of an actual cross-origin request, replacing those set by outer policies
+func (c *cors) apply(header http.Header, origin string) {
+	header.Del(corsHeaderAllowOrigin)
+	header.Del(corsHeaderAllowCredentials)
+	header.Del(corsHeaderExposeHeaders)
+
+	addVary(header, originHeader)
+
+	if !c.originAllowed(origin) {
+		return
+	}
+
+	c.setOrigin(header, origin)
+	if c.exposed != "" {
+		header.Set(corsHeaderExposeHeaders, c.exposed)
+	}
+}
+
+func (c *cors) replyPreflight(rw http.ResponseWriter, req *http.Request) {
+	header := rw.Header()
+	addVary(header, originHeader, corsHeaderRequestMethod, corsHeaderRequestHeaders)
+
+	origin := req.Header.Get(originHeader)
+	if !c.originAllowed(origin) || !c.allowedMethods[strings.ToUpper(req.Header.Get(corsHeaderRequestMethod))] {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	requested := req.Header.Get(corsHeaderRequestHeaders)
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.TrimSpace(h); h != "" && !c.allowedHeaders[http.CanonicalHeaderKey(h)] {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	c.setOrigin(header, origin)
+	header.Set(corsHeaderAllowMethods, c.methods)
+	if requested != "" {
+		header.Set(corsHeaderAllowHeaders, requested)
+	}
+
+	if c.maxAge != "" {
+		header.Set(corsHeaderMaxAge, c.maxAge)
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *cors) setOrigin(header http.Header, origin string) {
+	if c.anyOrigin {
+		header.Set(corsHeaderAllowOrigin, "*")
+		return
+	}
+
+	header.Set(corsHeaderAllowOrigin, origin)
+	if c.policy.AllowCredentials {
+		header.Set(corsHeaderAllowCredentials, "true")
+	}
+}
+
+func addVary(header http.Header, keys ...string) {
+	for _, key := range keys {
+		found := false
+		for _, v := range header[varyHeader] {
+			for _, vv := range strings.Split(v, ",") {
+				if strings.EqualFold(strings.TrimSpace(vv), key) {
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			header.Add(varyHeader, key)
+		}
 	}
 }
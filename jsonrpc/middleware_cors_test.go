@@ -0,0 +1,164 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleCORSPolicy(t *testing.T) {
+	noop := func(rw http.ResponseWriter, req *http.Request) error { return nil }
+
+	root := NewMux("/v1", nil, mustCORSPolicy(t, CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		ExposedHeaders: []string{RequestIDHeader},
+		MaxAge:         10 * time.Minute,
+	}))
+	root.Handle("/Get", noop)
+
+	sub := NewMux("/Admin", nil, mustCORSPolicy(t, CORSPolicy{
+		AllowedOrigins:   []string{"https://admin.example.com"},
+		AllowedMethods:   []string{http.MethodPost},
+		AllowCredentials: true,
+	}))
+	sub.Handle("/Set", noop)
+	root.AddSubs(sub)
+
+	call := func(method, path, origin string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			req.Header.Set(originHeader, origin)
+		}
+
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		rec := httptest.NewRecorder()
+		root.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		origin string
+		allow  string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"https://a.example.org", "https://a.example.org"},
+		{"https://example.org", ""},
+		{"http://a.example.org", ""},
+		{"https://evil.com", ""},
+	}
+
+	for _, c := range cases {
+		rec := call(http.MethodPost, "/v1/Get", c.origin)
+		if got := rec.Header().Get(corsHeaderAllowOrigin); got != c.allow {
+			t.Fatalf("origin %s: expected allowed origin %q, got %q", c.origin, c.allow, got)
+		}
+
+		if rec.Header().Get(varyHeader) != originHeader {
+			t.Fatalf("origin %s: expected Vary: Origin, got %v", c.origin, rec.Header())
+		}
+	}
+
+	if rec := call(http.MethodPost, "/v1/Get", ""); rec.Header().Get(corsHeaderAllowOrigin) != "" {
+		t.Fatalf("unexpected cors headers without origin, %v", rec.Header())
+	}
+
+	rec := call(http.MethodPost, "/v1/Get", "https://app.example.com")
+	if rec.Header().Get(corsHeaderExposeHeaders) != RequestIDHeader || rec.Header().Get(corsHeaderAllowCredentials) != "" {
+		t.Fatalf("unexpected cors headers %v", rec.Header())
+	}
+
+	rec = call(http.MethodOptions, "/v1/Get", "https://app.example.com",
+		corsHeaderRequestMethod, http.MethodPost, corsHeaderRequestHeaders, "content-type, x-forceup-req-id")
+	if rec.Code != http.StatusNoContent || rec.Header().Get(corsHeaderMaxAge) != "600" ||
+		rec.Header().Get(corsHeaderAllowHeaders) != "content-type, x-forceup-req-id" {
+		t.Fatalf("unexpected preflight reply %d %v", rec.Code, rec.Header())
+	}
+
+	rec = call(http.MethodOptions, "/v1/Get", "https://app.example.com",
+		corsHeaderRequestMethod, http.MethodPost, corsHeaderRequestHeaders, "X-Unknown")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for disallowed headers, got %d", rec.Code)
+	}
+
+	// the sub mux overrides the policy of the root for both preflight & actual requests
+	rec = call(http.MethodOptions, "/v1/Admin/Set", "https://app.example.com", corsHeaderRequestMethod, http.MethodPost)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for origin disallowed by the sub mux, got %d", rec.Code)
+	}
+
+	rec = call(http.MethodOptions, "/v1/Admin/Set", "https://admin.example.com", corsHeaderRequestMethod, http.MethodPost)
+	if rec.Code != http.StatusNoContent || rec.Header().Get(corsHeaderAllowMethods) != http.MethodPost ||
+		rec.Header().Get(corsHeaderAllowCredentials) != "true" || rec.Header().Get(corsHeaderMaxAge) != "" {
+		t.Fatalf("unexpected preflight reply of the sub mux %d %v", rec.Code, rec.Header())
+	}
+
+	rec = call(http.MethodPost, "/v1/Admin/Set", "https://admin.example.com")
+	if rec.Header().Get(corsHeaderAllowOrigin) != "https://admin.example.com" || rec.Header().Get(corsHeaderExposeHeaders) != "" {
+		t.Fatalf("unexpected cors headers of the sub mux %v", rec.Header())
+	}
+}
+
+func TestHandleCORSDefault(t *testing.T) {
+	mux := NewMux("/v1", nil, HandleCORS())
+	mux.Handle("/Get", func(rw http.ResponseWriter, req *http.Request) error { return nil })
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/Get", nil)
+	req.Header.Set(originHeader, "https://any.com")
+	req.Header.Set(corsHeaderRequestMethod, http.MethodPost)
+	req.Header.Set(corsHeaderRequestHeaders, RequestIDHeader)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get(corsHeaderAllowOrigin) != "*" {
+		t.Fatalf("unexpected preflight reply %d %v", rec.Code, rec.Header())
+	}
+
+	// preflight requests to unknown paths are replied by the policy of the root mux
+	req.URL.Path = "/v1/Unknown"
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected preflight reply %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestHandleCORSPolicyCredentialsWithAnyOrigin(t *testing.T) {
+	if _, err := HandleCORSPolicy(CORSPolicy{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}); err == nil {
+		t.Fatal("expected error for credentials allowed with any origin")
+	}
+}
+
+func TestHandleCORSOptions(t *testing.T) {
+	called := false
+	mux := NewRootMux("", nil)
+	mux.Handle("/Create", func(rw http.ResponseWriter, req *http.Request) error {
+		called = true
+		return nil
+	})
+
+	for _, origin := range []string{"https://any.com", ""} {
+		req := httptest.NewRequest(http.MethodOptions, "/Create", nil)
+		if origin != "" {
+			req.Header.Set(originHeader, origin)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if called || rec.Code != http.StatusOK || rec.Header().Get(corsHeaderAllowOrigin) != "*" {
+			t.Fatalf("origin %q: expected OPTIONS answered by the cors policy, got %d %v, handler called %v", origin, rec.Code, rec.Header(), called)
+		}
+	}
+}
+
+func mustCORSPolicy(t *testing.T, p CORSPolicy) Middleware {
+	mw, err := HandleCORSPolicy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mw
+}
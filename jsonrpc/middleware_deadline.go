@@ -0,0 +1,93 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader http header carrying the remaining time of the caller's deadline in milliseconds
+const TimeoutHeader = "X-FORCEUP-TIMEOUT"
+
+// ReasonDeadlineExceeded is the reason of errors replied for requests running out of their deadlines
+const ReasonDeadlineExceeded = "DEADLINE_EXCEEDED"
+
+// DeadlineConfig configures HandleDeadline
+type DeadlineConfig struct {
+	// Default applies to requests without TimeoutHeader, zero means no deadline
+	Default time.Duration
+
+	// Max caps the timeout of all requests, zero means no cap
+	Max time.Duration
+
+	// Methods caps the timeout of routes with the given full patterns, overriding Max
+	Methods map[string]time.Duration
+}
+
+// HandleDeadline applies the timeout sent by the caller in TimeoutHeader to the request context,
+// capped by the maximum of the route. If the handler fails after the deadline expires,
+// the error is replaced by a 504 *RPCError, while handlers ignoring the context are not interrupted.
+func HandleDeadline(cfg DeadlineConfig) Middleware {
+	return func(inner HandlerFunc) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			timeout := cfg.Default
+			if v := req.Header.Get(TimeoutHeader); v != "" {
+				ms, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || ms <= 0 {
+					return NewRPCErrorWithCode(http.StatusBadRequest, "invalid "+TimeoutHeader+" header")
+				}
+
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+
+			max := cfg.Max
+			if m, ok := cfg.Methods[RoutePattern(req)]; ok {
+				max = m
+			}
+
+			if max > 0 && (timeout <= 0 || timeout > max) {
+				timeout = max
+			}
+
+			if timeout <= 0 {
+				return inner(rw, req)
+			}
+
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+
+			err := inner(rw, req.WithContext(ctx))
+			if err != nil && ctx.Err() == context.DeadlineExceeded {
+				return newDeadlineExceededError(timeout)
+			}
+
+			return err
+		}
+	}
+}
+
+func newDeadlineExceededError(timeout time.Duration) *RPCError {
+	msg := "deadline exceeded"
+	if timeout > 0 {
+		msg += " after " + timeout.String()
+	}
+
+	return NewRPCErrorWithCode(http.StatusGatewayTimeout, msg).WithReason(ReasonDeadlineExceeded)
+}
+
+// setTimeoutHeader sends the remaining time of the deadline of ctx, rounded up to milliseconds
+func setTimeoutHeader(ctx context.Context, header http.Header) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+
+	remaining := time.Until(deadline)
+	ms := int64((remaining + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+
+	header.Set(TimeoutHeader, strconv.FormatInt(ms, 10))
+}
@@ -0,0 +1,66 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestHandleDeadline(t *testing.T) {
+	received := make(chan time.Duration, 1)
+
+	mux := NewMux("", nil, HandleError(), HandleDeadline(DeadlineConfig{
+		Methods: map[string]time.Duration{"/Capped": 50 * time.Millisecond},
+	}))
+
+	mux.Handle("/Echo", func(rw http.ResponseWriter, req *http.Request) error {
+		ms, _ := strconv.Atoi(req.Header.Get(TimeoutHeader))
+		received <- time.Duration(ms) * time.Millisecond
+
+		deadline, ok := req.Context().Deadline()
+		if !ok || time.Until(deadline) > time.Second {
+			return NewRPCErrorWithCode(http.StatusInternalServerError, "deadline not applied")
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, "ok")})
+	})
+
+	mux.Handle("/Capped", func(rw http.ResponseWriter, req *http.Request) error {
+		<-req.Context().Done()
+		return req.Context().Err()
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cli := NewRPCClient(srv.URL, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := cli.Call(ctx, "/Echo", common.EMPTY, &common.SimpleResp{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := <-received; d <= 0 || d > time.Second {
+		t.Fatalf("unexpected timeout header %s", d)
+	}
+
+	err := cli.Call(ctx, "/Capped", common.EMPTY, &common.SimpleResp{})
+	if e, ok := err.(*RPCError); !ok || e.Code != http.StatusGatewayTimeout || e.Reason != ReasonDeadlineExceeded {
+		t.Fatalf("expected 504 from the server, got %v", err)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+
+	err = cli.Call(short, "/Capped", common.EMPTY, &common.SimpleResp{})
+	if e, ok := err.(*RPCError); !ok || e.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %v", err)
+	}
+}
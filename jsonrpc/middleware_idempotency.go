@@ -0,0 +1,365 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader http header carrying the key identifying retries of the same request
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader http header set on responses replayed by HandleIdempotency
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// ReasonIdempotencyInFlight is the reason of errors replied for duplicates of a request still in flight
+	ReasonIdempotencyInFlight = "IDEMPOTENCY_IN_FLIGHT"
+
+	// ReasonIdempotencyKeyReused is the reason of errors replied for a key reused with a different request
+	ReasonIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+
+	maxIdempotencyKeyLen = 255
+)
+
+// DefaultIdempotencyConfig default idempotency config
+var DefaultIdempotencyConfig = IdempotencyConfig{
+	TTL:         24 * time.Hour,
+	InFlightTTL: time.Minute,
+	MaxBodySize: 1 << 20,
+}
+
+// IdempotencyRecord is the state of a key kept by an IdempotencyStore
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request first sent with the key
+	Fingerprint string
+
+	// Done is false while the first request is in flight
+	Done bool
+
+	// Status, Header & Body are the response written by the handler, Status is zero if nothing is written
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Err is the error returned by the handler, replied by outer middlewares, e.g. HandleError
+	Err *RPCError
+}
+
+// IdempotencyStore keeps records of idempotency keys, which must be safe for concurrent use
+type IdempotencyStore interface {
+	// Reserve claims key for an in-flight request with fingerprint atomically for ttl,
+	// or returns the existing record if the key is already claimed
+	Reserve(key, fingerprint string, ttl time.Duration) (rec *IdempotencyRecord, reserved bool, err error)
+
+	// Extend renews the claim of a reserved key still in flight for another ttl
+	Extend(key string, ttl time.Duration) error
+
+	// Complete stores the final record of a reserved key for ttl
+	Complete(key string, rec *IdempotencyRecord, ttl time.Duration) error
+
+	// Release removes a reserved key, so that the request can be retried
+	Release(key string) error
+}
+
+// IdempotencyConfig configures HandleIdempotency
+type IdempotencyConfig struct {
+	// Store defaults to an in-memory store, use a shared one for services with multiple instances
+	Store IdempotencyStore
+
+	// Scope separates keys of different callers, defaults to the Authorization token
+	Scope func(req *http.Request) string
+
+	// TTL is how long responses are kept for retries
+	TTL time.Duration
+
+	// InFlightTTL bounds how long a key is claimed by an in-flight request after the process dies,
+	// the claim is renewed periodically while the request is being handled
+	InFlightTTL time.Duration
+
+	// MaxBodySize bounds the size of request bodies, larger ones are rejected with 413
+	MaxBodySize int64
+}
+
+// HandleIdempotency makes requests carrying IdempotencyKeyHeader safe to retry. The response of the first request
+// with a key is stored and replayed to the following ones with IdempotentReplayedHeader, including errors
+// except the retryable ones, i.e. 5xx, 429 & canceled requests, which release the key for another attempt.
+//
+// Reusing a key with a different request is rejected with a 422 *RPCError, and duplicates arriving while the first
+// request is in flight with a 409 *RPCError with RetryAfter. Streaming responses are not stored.
+func HandleIdempotency(cfg IdempotencyConfig) Middleware {
+	def := DefaultIdempotencyConfig
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryIdempotencyStore()
+	}
+
+	if cfg.Scope == nil {
+		cfg.Scope = func(req *http.Request) string {
+			return req.Header.Get(authorizationHeader)
+		}
+	}
+
+	if cfg.TTL <= 0 {
+		cfg.TTL = def.TTL
+	}
+
+	if cfg.InFlightTTL <= 0 {
+		cfg.InFlightTTL = def.InFlightTTL
+	}
+
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = def.MaxBodySize
+	}
+
+	return func(inner HandlerFunc) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			idemKey := req.Header.Get(IdempotencyKeyHeader)
+			if idemKey == "" {
+				return inner(rw, req)
+			}
+
+			if len(idemKey) > maxIdempotencyKeyLen {
+				return NewRPCErrorWithCode(http.StatusBadRequest, "invalid "+IdempotencyKeyHeader+" header")
+			}
+
+			var body []byte
+			if req.Body != nil {
+				b, err := ioutil.ReadAll(io.LimitReader(req.Body, cfg.MaxBodySize+1))
+				if err != nil {
+					return NewRPCErrorWithCode(http.StatusBadRequest, "unable to read request body")
+				}
+
+				if int64(len(b)) > cfg.MaxBodySize {
+					return NewRPCErrorWithCode(http.StatusRequestEntityTooLarge)
+				}
+
+				body = b
+				req.Body = ioutil.NopCloser(bytes.NewReader(b))
+			}
+
+			fp := sha256.New()
+			fp.Write([]byte(req.URL.Path))
+			fp.Write([]byte{0})
+			fp.Write(canonicalBody(req.Header.Get(contentTypeHeader), body))
+			fingerprint := hex.EncodeToString(fp.Sum(nil))
+
+			sk := sha256.Sum256([]byte(RoutePattern(req) + "\x00" + cfg.Scope(req) + "\x00" + idemKey))
+			storeKey := hex.EncodeToString(sk[:])
+
+			rec, reserved, err := cfg.Store.Reserve(storeKey, fingerprint, cfg.InFlightTTL)
+			if err != nil {
+				RequestLogger(req).Warnf("unable to reserve idempotency key, err=%v", err)
+				return NewRPCErrorWithCode(http.StatusServiceUnavailable, "idempotency store unavailable")
+			}
+
+			if !reserved {
+				return replayIdempotent(rw, rec, fingerprint)
+			}
+
+			bw := newBufferingWriter(rw, func(code int, header http.Header) bool {
+				return !isStreamResponse(header)
+			})
+
+			stopRenew := renewIdempotent(req, cfg.Store, storeKey, cfg.InFlightTTL)
+			herr := inner(bw, req)
+			stopRenew()
+
+			// streams are passed through, and can't be replayed
+			if bw.decided && !bw.buffering {
+				releaseIdempotent(req, cfg.Store, storeKey)
+				return herr
+			}
+
+			rec = &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Done:        true,
+			}
+
+			code := http.StatusOK
+			if bw.decided {
+				rec.Status = bw.code
+				rec.Header = bw.writtenHeader()
+				rec.Header.Del(RequestIDHeader)
+				rec.Body = bw.body.Bytes()
+
+				code = bw.code
+				if c, err := strconv.Atoi(rw.Header().Get(ResultCodeHeader)); err == nil {
+					code = c
+				}
+			}
+
+			if herr != nil {
+				rec.Err = ToRPCError(herr)
+				code = rec.Err.Code
+			}
+
+			if code >= http.StatusInternalServerError || code == http.StatusTooManyRequests || code == statusClientClosedRequest {
+				releaseIdempotent(req, cfg.Store, storeKey)
+			} else if err := cfg.Store.Complete(storeKey, rec, cfg.TTL); err != nil {
+				RequestLogger(req).Warnf("unable to store idempotent response, err=%v", err)
+			}
+
+			if bw.decided {
+				rw.WriteHeader(bw.code)
+				rw.Write(rec.Body)
+			}
+
+			return herr
+		}
+	}
+}
+
+func replayIdempotent(rw http.ResponseWriter, rec *IdempotencyRecord, fingerprint string) error {
+	if rec.Fingerprint != fingerprint {
+		return NewRPCErrorWithCode(http.StatusUnprocessableEntity, IdempotencyKeyHeader+" reused with a different request").
+			WithReason(ReasonIdempotencyKeyReused)
+	}
+
+	if !rec.Done {
+		return NewRPCErrorWithCode(http.StatusConflict, "request with the same "+IdempotencyKeyHeader+" in flight").
+			WithReason(ReasonIdempotencyInFlight).
+			WithRetryAfter(time.Second)
+	}
+
+	header := rw.Header()
+	replayHeader(header, rec.Header)
+	header.Set(IdempotentReplayedHeader, "true")
+
+	if rec.Status != 0 {
+		rw.WriteHeader(rec.Status)
+		rw.Write(rec.Body)
+	}
+
+	if rec.Err != nil {
+		e := *rec.Err
+		return &e
+	}
+
+	return nil
+}
+
+// renewIdempotent extends the claim of key every half of ttl, until the returned func is called
+func renewIdempotent(req *http.Request, store IdempotencyStore, key string, ttl time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-ticker.C:
+				if err := store.Extend(key, ttl); err != nil {
+					RequestLogger(req).Warnf("unable to extend idempotency key, err=%v", err)
+				}
+			}
+		}
+	}()
+
+	// wait for the renewal to quit, so that it never overrides the final record
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func releaseIdempotent(req *http.Request, store IdempotencyStore, key string) {
+	if err := store.Release(key); err != nil {
+		RequestLogger(req).Warnf("unable to release idempotency key, err=%v", err)
+	}
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore in memory, expired records are purged periodically
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{
+		records: map[string]*memoryIdempotencyRecord{},
+	}
+}
+
+type memoryIdempotencyRecord struct {
+	rec     *IdempotencyRecord
+	expires time.Time
+}
+
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*memoryIdempotencyRecord
+	lastPurge time.Time
+}
+
+func (s *memoryIdempotencyStore) Reserve(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge(now)
+
+	if r, ok := s.records[key]; ok && now.Before(r.expires) {
+		return r.rec, false, nil
+	}
+
+	s.records[key] = &memoryIdempotencyRecord{
+		rec:     &IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+
+	return nil, true, nil
+}
+
+func (s *memoryIdempotencyStore) Extend(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[key]; ok && !r.rec.Done {
+		r.expires = time.Now().Add(ttl)
+	}
+
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Complete(key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = &memoryIdempotencyRecord{
+		rec:     rec,
+		expires: time.Now().Add(ttl),
+	}
+
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// purge removes expired records at most once a minute
+func (s *memoryIdempotencyStore) purge(now time.Time) {
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+
+	s.lastPurge = now
+	for key, r := range s.records {
+		if !now.Before(r.expires) {
+			delete(s.records, key)
+		}
+	}
+}
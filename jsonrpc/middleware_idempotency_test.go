@@ -0,0 +1,207 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestHandleIdempotency(t *testing.T) {
+	var (
+		calls   int32
+		blocked = make(chan struct{})
+	)
+
+	mux := NewMux("/v1", nil, InjectRequestID(), HandleError(WithHTTPStatus()), HandleIdempotency(IdempotencyConfig{}))
+	mux.Handle("/Create", func(rw http.ResponseWriter, req *http.Request) error {
+		n := atomic.AddInt32(&calls, 1)
+
+		in := &common.SimpleResp{}
+		if err := DecodeRequest(req, in); err != nil {
+			return err
+		}
+
+		switch in.Res.GetMsg() {
+		case "block":
+			<-blocked
+
+		case "invalid":
+			return NewRPCErrorWithCode(http.StatusBadRequest, "invalid")
+
+		case "flaky":
+			if n%2 == 1 {
+				return NewRPCErrorWithCode(http.StatusServiceUnavailable)
+			}
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, in.Res.GetMsg()+strconv.Itoa(int(n)))})
+	})
+
+	call := func(key, msg string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Create", strings.NewReader(`{"res":{"msg":"`+msg+`"}}`))
+		req.Header.Set(contentTypeHeader, ContentTypeJSON)
+		req.Header.Set(IdempotencyKeyHeader, key)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := call("k1", "order")
+	second := call("k1", "order")
+	if calls != 1 || second.Body.String() != first.Body.String() || second.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("expected replayed response, got %d calls, %q", calls, second.Body.String())
+	}
+
+	if rec := call("k1", "other"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for reused key, got %d", rec.Code)
+	}
+
+	// client errors are replayed as well
+	call("k2", "invalid")
+	if rec := call("k2", "invalid"); rec.Code != http.StatusBadRequest || rec.Header().Get(IdempotentReplayedHeader) != "true" || calls != 2 {
+		t.Fatalf("expected replayed error, got %d, %d calls", rec.Code, calls)
+	}
+
+	// duplicates of an in-flight request are rejected
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- call("k3", "block")
+	}()
+
+	for atomic.LoadInt32(&calls) != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if rec := call("k3", "block"); rec.Code != http.StatusConflict || rec.Header().Get(retryAfterHeader) == "" {
+		t.Fatalf("expected 409 for in-flight duplicate, got %d", rec.Code)
+	}
+
+	close(blocked)
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Fatalf("expected first request succeeded, got %d", rec.Code)
+	}
+
+	// retryable errors release the key, so that the client retries it
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	policy := DefaultRetryPolicy
+	policy.InitialBackoff = time.Millisecond
+	cli := NewRPCClient(srv.URL, nil, WithRetryPolicy(policy))
+
+	atomic.StoreInt32(&calls, 4)
+	out := &common.SimpleResp{}
+	in := &common.SimpleResp{Res: common.NewResult(0, "flaky")}
+	if err := cli.Call(ContextWithIdempotencyKey(context.Background(), "k4"), "/v1/Create", in, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 6 || out.Res.Msg != "flaky6" {
+		t.Fatalf("expected the call retried once, got %d calls, %v", calls, out)
+	}
+}
+
+func TestIdempotencyRenewInFlight(t *testing.T) {
+	var calls int32
+	blocked := make(chan struct{})
+
+	mux := NewMux("/v1", nil, HandleError(WithHTTPStatus()), HandleIdempotency(IdempotencyConfig{InFlightTTL: 20 * time.Millisecond}))
+	mux.Handle("/Create", func(rw http.ResponseWriter, req *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		<-blocked
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	call := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Create", strings.NewReader(`{}`))
+		req.Header.Set(contentTypeHeader, ContentTypeJSON)
+		req.Header.Set(IdempotencyKeyHeader, "slow")
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- call()
+	}()
+
+	for atomic.LoadInt32(&calls) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the claim outlives InFlightTTL as long as the first request is being handled
+	time.Sleep(100 * time.Millisecond)
+	if rec := call(); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for in-flight duplicate, got %d", rec.Code)
+	}
+
+	close(blocked)
+	if rec := <-done; rec.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the request handled once, got %d, %d calls", rec.Code, calls)
+	}
+
+	if rec := call(); rec.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("expected replayed response, got %d", rec.Code)
+	}
+}
+
+func TestContextWithIdempotencyKeySingleCall(t *testing.T) {
+	ctx := ContextWithIdempotencyKey(context.Background(), "once")
+
+	if opts := newCallOptions(ctx, nil); opts.header.Get(IdempotencyKeyHeader) != "once" {
+		t.Fatalf("expected the key on the first call, got %v", opts.header)
+	}
+
+	if opts := newCallOptions(ctx, nil); opts.header.Get(IdempotencyKeyHeader) != "" {
+		t.Fatalf("expected no key on later calls, got %v", opts.header)
+	}
+}
+
+func TestIdempotencyOuterHeaders(t *testing.T) {
+	mux := NewMux("/v1", nil,
+		mustCORSPolicy(t, CORSPolicy{AllowedOrigins: []string{"https://a.com", "https://b.com"}}),
+		InjectRequestID(),
+		HandleIdempotency(IdempotencyConfig{}),
+	)
+	mux.Handle("/Create", func(rw http.ResponseWriter, req *http.Request) error {
+		rw.Header().Set("X-Custom", "1")
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	call := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Create", strings.NewReader(`{}`))
+		req.Header.Set(contentTypeHeader, ContentTypeJSON)
+		req.Header.Set(IdempotencyKeyHeader, "k")
+		req.Header.Set(originHeader, origin)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := call("https://a.com")
+	retry := call("https://b.com")
+
+	header := retry.Header()
+	if header.Get(IdempotentReplayedHeader) != "true" || header.Get("X-Custom") != "1" {
+		t.Fatalf("expected replayed response, got %v", header)
+	}
+
+	if header.Get(corsHeaderAllowOrigin) != "https://b.com" || header.Get(varyHeader) != originHeader {
+		t.Fatalf("expected the cors headers of the retry, got %v", header)
+	}
+
+	if id := header.Get(RequestIDHeader); id == "" || id == first.Header().Get(RequestIDHeader) {
+		t.Fatalf("expected the request id of the retry, got %q", id)
+	}
+}
@@ -0,0 +1,193 @@
+package jsonrpc
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	authorizationHeader = "Authorization"
+
+	// ReasonRateLimited is the reason of errors replied by HandleRateLimit
+	ReasonRateLimited = "RATE_LIMITED"
+
+	defaultRateLimitMaxKeys = 100000
+)
+
+// RateLimitKey derives the bucket key of a request, requests with an empty key are not limited
+type RateLimitKey func(req *http.Request) string
+
+// RateLimitByToken keys requests by the Authorization token
+func RateLimitByToken() RateLimitKey {
+	return func(req *http.Request) string {
+		return req.Header.Get(authorizationHeader)
+	}
+}
+
+// RateLimitByRemoteIP keys requests by the ip of the remote address
+func RateLimitByRemoteIP() RateLimitKey {
+	return func(req *http.Request) string {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+
+		return host
+	}
+}
+
+// RateLimitByRoute keys requests by the pattern of the route, i.e. a limit shared by all callers of a method
+func RateLimitByRoute() RateLimitKey {
+	return RoutePattern
+}
+
+// RateLimitByAny uses the first non-empty key, e.g. the token, and the remote ip for anonymous callers
+func RateLimitByAny(keys ...RateLimitKey) RateLimitKey {
+	return func(req *http.Request) string {
+		for _, key := range keys {
+			if k := key(req); k != "" {
+				return k
+			}
+		}
+
+		return ""
+	}
+}
+
+// RateLimit is a token bucket refilled by Rate tokens per second, holding at most Burst tokens
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig configures HandleRateLimit
+type RateLimitConfig struct {
+	// Limit applies to each key of all routes wrapped by the middleware, zero Rate means unlimited
+	Limit RateLimit
+
+	// Methods overrides Limit for routes with the given full patterns, e.g. "/v1/Order/Create",
+	// each with its own buckets
+	Methods map[string]RateLimit
+
+	// Key defaults to RateLimitByRemoteIP
+	Key RateLimitKey
+
+	// MaxKeys bounds the number of buckets, the least recently used ones are evicted once exceeded,
+	// which start over with a full bucket when their keys come back
+	MaxKeys int
+}
+
+// HandleRateLimit rejects requests exceeding the token bucket limit of their keys with a 429 *RPCError,
+// which carries the time to wait in RetryAfter. Use it per mux for limits of a group of methods.
+func HandleRateLimit(cfg RateLimitConfig) Middleware {
+	if cfg.Key == nil {
+		cfg.Key = RateLimitByRemoteIP()
+	}
+
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = defaultRateLimitMaxKeys
+	}
+
+	limiter := newRateLimiter(cfg.MaxKeys)
+
+	return func(inner HandlerFunc) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			limit := cfg.Limit
+
+			bucketKey := cfg.Key(req)
+			if bucketKey == "" {
+				return inner(rw, req)
+			}
+
+			pattern := RoutePattern(req)
+			if l, ok := cfg.Methods[pattern]; ok {
+				limit = l
+				bucketKey = pattern + "\x00" + bucketKey
+			}
+
+			if limit.Rate <= 0 {
+				return inner(rw, req)
+			}
+
+			if wait := limiter.take(bucketKey, limit, time.Now()); wait > 0 {
+				return NewRPCErrorWithCode(http.StatusTooManyRequests).
+					WithReason(ReasonRateLimited).
+					WithRetryAfter(wait)
+			}
+
+			return inner(rw, req)
+		}
+	}
+}
+
+// rateLimiter keeps at most maxKeys buckets, evicting the least recently used ones
+type rateLimiter struct {
+	mu      sync.Mutex
+	maxKeys int
+	buckets map[string]*list.Element
+	order   *list.List
+}
+
+func newRateLimiter(maxKeys int) *rateLimiter {
+	return &rateLimiter{
+		maxKeys: maxKeys,
+		buckets: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+type tokenBucket struct {
+	key    string
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// take consumes a token from the bucket of key, and returns how long to wait if there is none
+func (rl *rateLimiter) take(key string, limit RateLimit, now time.Time) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var b *tokenBucket
+	if elem, ok := rl.buckets[key]; ok {
+		rl.order.MoveToFront(elem)
+		b = elem.Value.(*tokenBucket)
+		b.limit = limit
+	} else {
+		b = &tokenBucket{
+			key:    key,
+			limit:  limit,
+			tokens: burstOf(limit),
+			last:   now,
+		}
+
+		rl.buckets[key] = rl.order.PushFront(b)
+		for rl.order.Len() > rl.maxKeys {
+			oldest := rl.order.Back()
+			rl.order.Remove(oldest)
+			delete(rl.buckets, oldest.Value.(*tokenBucket).key)
+		}
+	}
+
+	b.tokens = math.Min(burstOf(b.limit), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func burstOf(limit RateLimit) float64 {
+	if limit.Burst < 1 {
+		return 1
+	}
+
+	return float64(limit.Burst)
+}
@@ -0,0 +1,105 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHandleRateLimit(t *testing.T) {
+	noop := func(rw http.ResponseWriter, req *http.Request) error { return nil }
+
+	mux := NewMux("/v1", nil, HandleError(WithHTTPStatus()), HandleRateLimit(RateLimitConfig{
+		Limit:   RateLimit{Rate: 1, Burst: 2},
+		Methods: map[string]RateLimit{"/v1/Write": {Rate: 0.5, Burst: 1}},
+		Key:     RateLimitByAny(RateLimitByToken(), RateLimitByRemoteIP()),
+	}))
+	mux.Handle("/Read", noop)
+	mux.Handle("/Write", noop)
+
+	call := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set(authorizationHeader, token)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rec := call("/v1/Read", "tenant-a"); rec.Code != want {
+			t.Fatalf("read #%d: expected %d, got %d", i, want, rec.Code)
+		}
+	}
+
+	// buckets of other tokens, methods with own limits & anonymous callers are independent
+	if rec := call("/v1/Read", "tenant-b"); rec.Code != http.StatusOK {
+		t.Fatalf("expected tenant-b not limited, got %d", rec.Code)
+	}
+
+	if rec := call("/v1/Write", "tenant-a"); rec.Code != http.StatusOK {
+		t.Fatalf("expected first write allowed, got %d", rec.Code)
+	}
+
+	rec := call("/v1/Write", "tenant-a")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected second write limited, got %d", rec.Code)
+	}
+
+	if secs, _ := strconv.Atoi(rec.Header().Get(retryAfterHeader)); secs != 2 {
+		t.Fatalf("expected retry after 2s, got %q", rec.Header().Get(retryAfterHeader))
+	}
+
+	if rec := call("/v1/Read", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected anonymous caller allowed, got %d", rec.Code)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	rl := newRateLimiter(2)
+	limit := RateLimit{Rate: 10, Burst: 1}
+	now := time.Now()
+
+	if wait := rl.take("a", limit, now); wait != 0 {
+		t.Fatalf("expected token, wait %s", wait)
+	}
+
+	if wait := rl.take("a", limit, now); wait != 100*time.Millisecond {
+		t.Fatalf("expected wait 100ms, got %s", wait)
+	}
+
+	if wait := rl.take("a", limit, now.Add(100*time.Millisecond)); wait != 0 {
+		t.Fatalf("expected refilled token, wait %s", wait)
+	}
+
+	// "a" is used more recently than "b", so "b" is evicted for "c"
+	rl.take("b", limit, now)
+	rl.take("a", limit, now.Add(200*time.Millisecond))
+	rl.take("c", limit, now.Add(200*time.Millisecond))
+	if _, ok := rl.buckets["b"]; ok || len(rl.buckets) != 2 {
+		t.Fatalf("expected the least recently used bucket evicted, got %d buckets", len(rl.buckets))
+	}
+
+	if wait := rl.take("a", limit, now.Add(200*time.Millisecond)); wait == 0 {
+		t.Fatal("expected the state of a kept bucket")
+	}
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	rl := newRateLimiter(100)
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	// keys of different limits never idle long enough are still bounded
+	for i := 0; i < 10000; i++ {
+		rl.take(strconv.Itoa(i), RateLimit{Rate: limit.Rate, Burst: i%5 + 1}, now)
+	}
+
+	if len(rl.buckets) != 100 || rl.order.Len() != 100 {
+		t.Fatalf("expected 100 buckets, got %d", len(rl.buckets))
+	}
+}
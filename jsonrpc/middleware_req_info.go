@@ -1,6 +1,9 @@
 package jsonrpc
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"net/http"
 	"time"
 )
@@ -24,7 +27,7 @@ func HandleRequestInfoLogging() Middleware {
 			dur := time.Since(before)
 
 			// logging
-			RequestLogger(req).Infof("[%d][%s] %s %s", wrapped.code, req.Method, req.RequestURI, dur)
+			RequestLogger(req).Infof("[%d][%s] %s %s req_id=%s", wrapped.code, req.Method, req.RequestURI, dur, RequestID(req))
 
 			// rpc metric
 			rpcMetricAdd(req.URL.Path, wrapped.code, dur)
@@ -35,6 +38,8 @@ func HandleRequestInfoLogging() Middleware {
 
 var (
 	_ http.ResponseWriter = (*wrappedResponseWritter)(nil)
+	_ http.Flusher        = (*wrappedResponseWritter)(nil)
+	_ http.Hijacker       = (*wrappedResponseWritter)(nil)
 )
 
 type wrappedResponseWritter struct {
@@ -54,6 +59,26 @@ func (wrw *wrappedResponseWritter) Write(b []byte) (int, error) {
 func (wrw *wrappedResponseWritter) WriteHeader(code int) {
 	if !wrw.codeWritten {
 		wrw.inner.WriteHeader(code)
+		wrw.code = code
 		wrw.codeWritten = true
 	}
 }
+
+// Flush implements http.Flusher for streaming responses
+func (wrw *wrappedResponseWritter) Flush() {
+	if f, ok := wrw.inner.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for protocol upgrades, e.g. websocket
+func (wrw *wrappedResponseWritter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := wrw.inner.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", wrw.inner)
+	}
+
+	wrw.code = http.StatusSwitchingProtocols
+	wrw.codeWritten = true
+	return h.Hijack()
+}
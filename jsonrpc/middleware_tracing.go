@@ -0,0 +1,75 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// HandleTracing starts a server span for each request, named after the route pattern, e.g. "/v1/Order/Create",
+// as a child of the span sent by the caller in the traceparent header. The span is available to handlers
+// by SpanFromContext, and RPCClient sends it to downstream services.
+//
+// Errors returned by inner handlers are recorded, and set the status of the span for 5xx codes.
+// Placed outside HandleError, only the code of errors is known from ResultCodeHeader.
+func HandleTracing(t *Tracer) Middleware {
+	return func(inner HandlerFunc) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			parent, _ := extractTraceContext(req.Header)
+
+			name := RoutePattern(req)
+			if name == "" {
+				name = "HTTP " + req.Method
+			}
+
+			span := t.start(parent, name, SpanKindServer)
+			defer span.End()
+
+			if span.recording() {
+				span.SetAttribute("rpc.system", "jsonrpc")
+				span.SetAttribute("http.method", req.Method)
+				span.SetAttribute("http.route", RoutePattern(req))
+				if reqID, ok := RequestIDFromContext(req.Context()); ok {
+					span.SetAttribute("request.id", reqID)
+				}
+			}
+
+			req = req.WithContext(ContextWithSpan(req.Context(), span))
+
+			wrapped := &wrappedResponseWritter{
+				inner: rw,
+			}
+
+			err := inner(wrapped, req)
+			if !span.recording() {
+				return err
+			}
+
+			code := wrapped.code
+			if code == 0 && err == nil {
+				code = http.StatusOK
+			}
+
+			if code != 0 {
+				span.SetAttribute("http.status_code", code)
+			}
+
+			if c, cerr := strconv.Atoi(rw.Header().Get(ResultCodeHeader)); cerr == nil {
+				code = c
+			}
+
+			msg := ""
+			if err != nil {
+				e := ToRPCError(err)
+				span.RecordError(err)
+				code, msg = e.Code, e.Msg
+			}
+
+			span.SetAttribute("rpc.code", code)
+			if code >= http.StatusInternalServerError {
+				span.SetStatus(SpanStatusError, msg)
+			}
+
+			return err
+		}
+	}
+}
@@ -2,19 +2,36 @@ package jsonrpc
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/ipfs-force-community/common"
 	"github.com/ipfs-force-community/gosf/proc"
 )
 
-// RegisterMux register a jsonrpc mux onto the given std *http.ServeMux, and uses http.DefaultServeMux if stdmux is nil
+var _ http.Handler = (*Mux)(nil)
+
+// RegisterMux register a jsonrpc mux onto the given std *http.ServeMux, and uses http.DefaultServeMux if stdmux is nil.
+// Requests are still routed by jmux itself, routes registered later or removed are honored as well,
+// except that new path prefixes require another call of RegisterMux, which skips patterns already registered
 func RegisterMux(stdmux *http.ServeMux, jmux *Mux) {
 	if stdmux == nil {
 		stdmux = http.DefaultServeMux
 	}
 
-	jmux.register("", nil, stdmux)
+	jmux.register(stdmux)
+
+	if !stdmuxRegistered(stdmux, "/_version") {
+		proc.RegisterVersionHandler(stdmux)
+	}
+}
 
-	proc.RegisterVersionHandler(stdmux)
+// stdmuxRegistered reports whether the exact pattern is registered on stdmux, which panics on duplicates
+func stdmuxRegistered(stdmux *http.ServeMux, pattern string) bool {
+	_, matched := stdmux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: pattern}})
+	return matched == pattern
 }
 
 // NewMux returns a json mux with given prefix, logger & middlewares
@@ -42,62 +59,233 @@ func NewRootMux(prefix string, logger Logger) *Mux {
 	return NewMux(prefix, logger, mds...)
 }
 
-// Mux represents a simple multiplexer for jsonrpc requests
+// Mux represents a simple multiplexer for jsonrpc requests, which routes requests by itself as an http.Handler.
+//
+// A pattern is matched against the path of the request. Segments wrapped in braces, e.g. "/orders/{id}",
+// match any single segment, whose value is returned by PathParam, and a pattern ending with a slash
+// matches the whole subtree below it. Exact patterns take precedence over those with parameters,
+// and the longest subtree pattern wins. Requests matching no pattern are replied with 404,
+// and those matching a pattern without a handler for the http method with 405, through the middlewares of the mux.
 type Mux struct {
+	mu       sync.RWMutex
 	prefix   string
 	handlers []patternedHandler
 	midwares []Middleware
 	subs     []*Mux
+	parents  []*Mux
 	logger   Logger
+
+	// gen is increased on each change of the mux tree, and table is rebuilt if it was resolved for an older gen
+	gen   uint64
+	table atomic.Value
+}
+
+type routeTable struct {
+	gen    uint64
+	router *router
 }
 
 // Use appends a group of middlewares to the current mux
 func (m *Mux) Use(mw ...Middleware) {
+	m.mu.Lock()
 	m.midwares = append(m.midwares, mw...)
+	m.mu.Unlock()
+
+	m.changed()
 }
 
-// Handle register a handler func for the given pattern
-func (m *Mux) Handle(pattern string, handler HandlerFunc) {
-	m.handlers = append(m.handlers, patternedHandler{
+// Handle register a handler func for the given pattern, serving any http method
+func (m *Mux) Handle(pattern string, handler HandlerFunc, opts ...RouteOption) {
+	m.HandleMethod("", pattern, handler, opts...)
+}
+
+// HandleMethod register a handler func for the given http method & pattern, an empty method means any
+func (m *Mux) HandleMethod(method, pattern string, handler HandlerFunc, opts ...RouteOption) {
+	h := patternedHandler{
+		method:  strings.ToUpper(method),
 		pattern: pattern,
 		handler: handler,
-	})
+	}
+
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	m.mu.Lock()
+	m.handlers = append(m.handlers, h)
+	m.mu.Unlock()
+
+	m.changed()
+}
+
+// Remove unregisters all handlers of the given pattern from the current mux, regardless of http methods
+func (m *Mux) Remove(pattern string) {
+	m.mu.Lock()
+	handlers := m.handlers[:0:0]
+	for _, h := range m.handlers {
+		if h.pattern != pattern {
+			handlers = append(handlers, h)
+		}
+	}
+	m.handlers = handlers
+	m.mu.Unlock()
+
+	m.changed()
 }
 
 // AddSubs appends sub muxes to the current mux
 func (m *Mux) AddSubs(subs ...*Mux) {
+	m.mu.Lock()
 	m.subs = append(m.subs, subs...)
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.mu.Lock()
+		sub.parents = append(sub.parents, m)
+		sub.mu.Unlock()
+	}
+
+	m.changed()
 }
 
-func (m *Mux) register(prefix string, mds []Middleware, stdmux *http.ServeMux) {
-	logger := m.logger
-	if logger == nil {
-		logger = stdLogger
+// changed invalidates the resolved routing tables of the mux and all its ancestors
+func (m *Mux) changed() {
+	atomic.AddUint64(&m.gen, 1)
+
+	m.mu.RLock()
+	parents := m.parents
+	m.mu.RUnlock()
+
+	for _, p := range parents {
+		p.changed()
+	}
+}
+
+// ServeHTTP dispatches the request to the handler matching its path & method
+func (m *Mux) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	m.router().ServeHTTP(rw, req)
+}
+
+func (m *Mux) router() *router {
+	gen := atomic.LoadUint64(&m.gen)
+	if t, ok := m.table.Load().(*routeTable); ok && t.gen == gen {
+		return t.router
+	}
+
+	m.mu.RLock()
+	mds := m.midwares[:len(m.midwares):len(m.midwares)]
+	m.mu.RUnlock()
+
+	rt := newRouter(m.routes("", nil))
+	rt.logger = m.logger
+	rt.fallback = replyRouteError
+	for size := len(mds); size > 0; size-- {
+		rt.fallback = mds[size-1](rt.fallback)
+	}
+
+	m.table.Store(&routeTable{gen: gen, router: rt})
+	return rt
+}
+
+// register registers the path of each route onto stdmux, truncated before the first path parameter,
+// and leaves the dispatching to the mux
+func (m *Mux) register(stdmux *http.ServeMux) {
+	registered := map[string]bool{}
+	for _, r := range m.routes("", nil) {
+		pattern := r.pattern
+		if i := strings.Index(pattern, "{"); i >= 0 {
+			pattern = pattern[:strings.LastIndex(pattern[:i], "/")+1]
+		}
+
+		if !registered[pattern] && !stdmuxRegistered(stdmux, pattern) {
+			stdmux.Handle(pattern, m)
+		}
+
+		registered[pattern] = true
 	}
+}
+
+// routes resolves full patterns & middleware-wrapped handlers for the mux and all its subs
+func (m *Mux) routes(prefix string, mds []Middleware) []route {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
 	prefix += m.prefix
 	if prefix == "/" {
 		prefix = ""
 	}
 
-	mds = append(mds, m.midwares...)
+	mds = append(mds[:len(mds):len(mds)], m.midwares...)
+
+	routes := make([]route, 0, len(m.handlers))
 
 	for _, patternedHdl := range m.handlers {
+		pattern := prefix + patternedHdl.pattern
 
 		wrappedHdl := patternedHdl.handler
 		for size := len(mds); size > 0; size-- {
 			wrappedHdl = mds[size-1](wrappedHdl)
 		}
 
-		stdmux.Handle(prefix+patternedHdl.pattern, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			err := wrappedHdl(rw, req)
-			if err != nil {
-				logger.Errorf("unhandled error captured, method=%s, cause=%s", req.RequestURI, err.Error())
-			}
-		}))
+		// preflight requests go through the same middlewares, and are replied by the cors policy in effect
+		preflight := HandlerFunc(replyPreflight)
+		for size := len(mds); size > 0; size-- {
+			preflight = mds[size-1](preflight)
+		}
+
+		meta := &routeMeta{
+			pattern: pattern,
+			scope:   patternedHdl.scope,
+			perm:    patternedHdl.perm,
+			cache:   patternedHdl.cache,
+		}
+
+		wrappedHdl = injectRouteMeta(meta, wrappedHdl)
+		preflight = injectRouteMeta(meta, preflight)
+
+		routes = append(routes, route{
+			method:    patternedHdl.method,
+			pattern:   pattern,
+			muxPrefix: prefix,
+			handler:   wrappedHdl,
+			preflight: preflight,
+			mds:       mds,
+			scope:     patternedHdl.scope,
+			perm:      patternedHdl.perm,
+			logger:    m.logger,
+		})
 	}
 
 	for _, sub := range m.subs {
-		sub.register(prefix, mds, stdmux)
+		routes = append(routes, sub.routes(prefix, mds)...)
+	}
+
+	return routes
+}
+
+// route is a registered handler with its full pattern & the whole middleware chain applied
+type route struct {
+	method    string
+	pattern   string
+	muxPrefix string
+	handler   HandlerFunc
+	preflight HandlerFunc
+	mds       []Middleware
+	scope     string
+	perm      common.Perm
+	logger    Logger
+}
+
+func (r route) httpHandler() http.Handler {
+	logger := r.logger
+	if logger == nil {
+		logger = stdLogger
 	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		err := r.handler(rw, req)
+		if err != nil {
+			logger.Errorf("unhandled error captured, method=%s, cause=%s", req.RequestURI, err.Error())
+		}
+	})
 }
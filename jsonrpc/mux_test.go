@@ -0,0 +1,146 @@
+package jsonrpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestMuxRouting(t *testing.T) {
+	reply := func(body string) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			_, err := rw.Write([]byte(body + PathParam(req, "id") + PathParam(req, "item")))
+			return err
+		}
+	}
+
+	root := NewMux("/v1", nil, HandleError(WithHTTPStatus()))
+	root.Handle("/Svc/Method", reply("method"))
+	root.HandleMethod(http.MethodGet, "/orders/{id}", reply("get:"))
+	root.HandleMethod(http.MethodDelete, "/orders/{id}", reply("delete:"))
+	root.Handle("/orders/latest", reply("latest"))
+	root.Handle("/orders/{id}/items/{item}", reply("item:"))
+	root.Handle("/static/", reply("static"))
+
+	sub := NewMux("/sub", nil)
+	sub.Handle("/Ping", reply("pong"))
+	root.AddSubs(sub)
+
+	srv := httptest.NewServer(root)
+	defer srv.Close()
+
+	do := func(method, path string) (int, string, http.Header) {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), resp.Header
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{http.MethodPost, "/v1/Svc/Method", http.StatusOK, "method"},
+		{http.MethodGet, "/v1/orders/7", http.StatusOK, "get:7"},
+		{http.MethodDelete, "/v1/orders/7", http.StatusOK, "delete:7"},
+		{http.MethodGet, "/v1/orders/latest", http.StatusOK, "latest"},
+		{http.MethodPost, "/v1/orders/7/items/3", http.StatusOK, "item:73"},
+		{http.MethodGet, "/v1/static/js/app.js", http.StatusOK, "static"},
+		{http.MethodPost, "/v1/sub/Ping", http.StatusOK, "pong"},
+		{http.MethodGet, "/v1/static", http.StatusNotFound, ""},
+		{http.MethodPost, "/v1/Svc/Other", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		code, body, _ := do(c.method, c.path)
+		if code != c.code || (c.body != "" && body != c.body) {
+			t.Fatalf("%s %s: expected %d %q, got %d %q", c.method, c.path, c.code, c.body, code, body)
+		}
+	}
+
+	code, _, header := do(http.MethodPost, "/v1/orders/7")
+	if code != http.StatusMethodNotAllowed || header.Get(allowHeader) != "DELETE, GET" {
+		t.Fatalf("expected 405 with Allow header, got %d %q", code, header.Get(allowHeader))
+	}
+
+	sub.Remove("/Ping")
+	if code, _, _ := do(http.MethodPost, "/v1/sub/Ping"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after removal, got %d", code)
+	}
+
+	sub.Handle("/Pong", reply("ping"))
+	if code, body, _ := do(http.MethodPost, "/v1/sub/Pong"); code != http.StatusOK || body != "ping" {
+		t.Fatalf("expected route added after serving, got %d %q", code, body)
+	}
+}
+
+func TestMuxRoutes(t *testing.T) {
+	noop := func(rw http.ResponseWriter, req *http.Request) error { return nil }
+
+	root := NewMux("/v1", nil, HandleError(), HandlePanic())
+	sub := NewMux("/Svc", nil, HandleCORS())
+	sub.Handle("/Get", noop, WithAccess("svc.get", common.Perm_READ))
+	sub.HandleMethod(http.MethodGet, "/items/{id}", noop)
+	root.AddSubs(sub)
+
+	infos := root.Routes()
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(infos))
+	}
+
+	get := infos[0]
+	if get.Path != "/v1/Svc/Get" || get.MuxPrefix != "/v1/Svc" || get.Scope != "svc.get" || get.Perm != common.Perm_READ {
+		t.Fatalf("unexpected route %+v", get)
+	}
+
+	if got := strings.Join(get.Middlewares, ","); got != "jsonrpc.HandleError,jsonrpc.HandlePanic,jsonrpc.HandleCORS" {
+		t.Fatalf("unexpected middlewares %s", got)
+	}
+
+	if infos[1].Method != http.MethodGet || infos[1].Path != "/v1/Svc/items/{id}" {
+		t.Fatalf("unexpected route %+v", infos[1])
+	}
+}
+
+func TestRegisterMuxTwice(t *testing.T) {
+	reply := func(msg string) HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) error {
+			_, err := rw.Write([]byte(msg))
+			return err
+		}
+	}
+
+	stdmux := http.NewServeMux()
+	root := NewMux("/v1", nil)
+	root.Handle("/Get", reply("get"))
+	RegisterMux(stdmux, root)
+
+	sub := NewMux("/v2", nil)
+	sub.Handle("/Get", reply("v2"))
+	root.AddSubs(sub)
+	RegisterMux(stdmux, root)
+
+	for path, want := range map[string]string{"/v1/Get": "get", "/v1/v2/Get": "v2"} {
+		rec := httptest.NewRecorder()
+		stdmux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Body.String() != want {
+			t.Fatalf("%s: expected %q, got %d %q", path, want, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -42,14 +42,44 @@ func genRequestID() string {
 	return fmt.Sprintf("%s:%s", base64.URLEncoding.EncodeToString(b[:]), machineID)
 }
 
-// InjectRequestID injects an id for each request
+const maxRequestIDLen = 128
+
+// validRequestID reports whether an inbound request id is safe to log & forward
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '=' || c == '+' || c == '/':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// InjectRequestID injects an id for each request, and replies it in RequestIDHeader.
+// A valid id sent by the caller in RequestIDHeader is honored, so that a request can be traced across services,
+// otherwise the id already in the context, e.g. of a json-rpc 2.0 batch, is kept, or a new one is generated
 func InjectRequestID() Middleware {
 	return func(inner HandlerFunc) HandlerFunc {
 		return func(rw http.ResponseWriter, req *http.Request) error {
-			reqID := genRequestID()
-			req = Inject(req, ctxKeyReqID, reqID)
+			reqID := req.Header.Get(RequestIDHeader)
+			if !validRequestID(reqID) {
+				reqID, _ = RequestIDFromContext(req.Context())
+			}
+
+			if reqID == "" {
+				reqID = genRequestID()
+			}
 
-			rw.Header().Set(RequestIDHeader, genRequestID())
+			req = Inject(req, ctxKeyReqID, reqID)
+			rw.Header().Set(RequestIDHeader, reqID)
 
 			return inner(rw, req)
 		}
@@ -58,14 +88,25 @@ func InjectRequestID() Middleware {
 
 // RequestID extracts request id from context
 func RequestID(req *http.Request) string {
-	id, _ := Extract(req, ctxKeyReqID).(string)
-	if id != "" {
+	if id, ok := RequestIDFromContext(req.Context()); ok {
 		return id
 	}
 
 	return genRequestID()
 }
 
+// RequestIDFromContext returns the request id injected by InjectRequestID or ContextWithRequestID
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, _ := ctx.Value(ctxKeyReqID).(string)
+	return id, id != ""
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request id, which RPCClient forwards to downstream calls,
+// e.g. for calls made by background jobs
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKeyReqID, id)
+}
+
 // InjectHTTPRequest injects given *http.Request into context
 func InjectHTTPRequest(req *http.Request) *http.Request {
 	pureReq := req.WithContext(context.Background())
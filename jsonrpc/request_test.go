@@ -0,0 +1,91 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestInjectRequestID(t *testing.T) {
+	var seen string
+	mux := NewMux("/v1", nil, InjectRequestID())
+	mux.Handle("/Get", func(rw http.ResponseWriter, req *http.Request) error {
+		seen = RequestID(req)
+		return nil
+	})
+
+	for _, c := range []struct {
+		inbound string
+		honored bool
+	}{
+		{"", false},
+		{"upstream-id:abc_DEF=", true},
+		{"bad id\n", false},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Get", nil)
+		if c.inbound != "" {
+			req.Header.Set(RequestIDHeader, c.inbound)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		replied := rec.Header().Get(RequestIDHeader)
+		if replied == "" || replied != seen {
+			t.Fatalf("inbound %q: replied id %q differs from the injected one %q", c.inbound, replied, seen)
+		}
+
+		if (replied == c.inbound) != c.honored {
+			t.Fatalf("inbound %q: unexpected id %q", c.inbound, replied)
+		}
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	var downstreamID string
+	downstream := NewMux("/v1", nil, InjectRequestID(), HandleError())
+	downstream.Handle("/Get", func(rw http.ResponseWriter, req *http.Request) error {
+		downstreamID = RequestID(req)
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	cli := NewInProcessRPCClient(downstream)
+
+	upstream := NewMux("/v1", nil, InjectRequestID(), HandleError())
+	upstream.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		if err := cli.Call(req.Context(), "/v1/Get", common.EMPTY, &common.SimpleResp{}); err != nil {
+			return err
+		}
+
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	rec := httptest.NewRecorder()
+	upstream.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/Call", nil))
+
+	if id := rec.Header().Get(RequestIDHeader); id == "" || id != downstreamID {
+		t.Fatalf("expected the downstream call with request id %q, got %q", id, downstreamID)
+	}
+
+	ctx := ContextWithRequestID(context.Background(), "job-1")
+	if err := cli.Call(ctx, "/v1/Get", common.EMPTY, &common.SimpleResp{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if downstreamID != "job-1" {
+		t.Fatalf("expected request id from context, got %q", downstreamID)
+	}
+
+	if err := cli.Call(ctx, "/v1/Get", common.EMPTY, &common.SimpleResp{}, WithHeader(RequestIDHeader, "explicit")); err != nil {
+		t.Fatal(err)
+	}
+
+	if downstreamID != "explicit" {
+		t.Fatalf("expected request id set by WithHeader, got %q", downstreamID)
+	}
+}
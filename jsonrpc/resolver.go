@@ -0,0 +1,124 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the endpoints of a service, i.e. base urls like "http://10.0.0.1:8080",
+// it is called periodically by the client created with NewBalancedRPCClient
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// ResolverFunc adapts a function to Resolver
+type ResolverFunc func(ctx context.Context) ([]string, error)
+
+// Resolve calls f
+func (f ResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// StaticResolver resolves to the given endpoints
+func StaticResolver(endpoints ...string) Resolver {
+	return ResolverFunc(func(context.Context) ([]string, error) {
+		return endpoints, nil
+	})
+}
+
+// DNSResolver resolves the A & AAAA records of host, e.g. a headless service, into endpoints
+// with the given scheme & port
+func DNSResolver(scheme, host string, port int) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, scheme+"://"+net.JoinHostPort(addr, strconv.Itoa(port)))
+		}
+
+		return endpoints, nil
+	})
+}
+
+// DNSSRVResolver resolves the SRV records of _service._proto.name into endpoints with the given scheme
+func DNSSRVResolver(scheme, service, proto, name string) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints := make([]string, 0, len(srvs))
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			endpoints = append(endpoints, scheme+"://"+net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		}
+
+		return endpoints, nil
+	})
+}
+
+// FileResolver reads endpoints from a local file, one per line, blank lines & lines starting with # are ignored.
+// The file is re-read whenever it is modified, so that endpoints can be updated by rewriting it
+func FileResolver(path string) Resolver {
+	return &fileResolver{path: path}
+}
+
+type fileResolver struct {
+	path string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	size      int64
+	endpoints []string
+}
+
+func (fr *fileResolver) Resolve(context.Context) ([]string, error) {
+	fi, err := os.Stat(fr.path)
+	if err != nil {
+		return nil, err
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if fr.endpoints != nil && fi.ModTime().Equal(fr.modTime) && fi.Size() == fr.size {
+		return fr.endpoints, nil
+	}
+
+	data, err := ioutil.ReadFile(fr.path)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		endpoints = append(endpoints, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read endpoints from %s, err=%v", fr.path, err)
+	}
+
+	fr.modTime, fr.size, fr.endpoints = fi.ModTime(), fi.Size(), endpoints
+	return endpoints, nil
+}
@@ -0,0 +1,170 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	_ http.ResponseWriter = (*responseBuffer)(nil)
+)
+
+// responseBuffer is a http.ResponseWriter keeping status, headers & body in memory
+type responseBuffer struct {
+	code   int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{
+		header: http.Header{},
+	}
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	if rb.code == 0 {
+		rb.code = http.StatusOK
+	}
+
+	return rb.body.Write(b)
+}
+
+func (rb *responseBuffer) WriteHeader(code int) {
+	if rb.code == 0 {
+		rb.code = code
+	}
+}
+
+// status returns the written status code, http.StatusOK if nothing has been written
+func (rb *responseBuffer) status() int {
+	if rb.code == 0 {
+		return http.StatusOK
+	}
+
+	return rb.code
+}
+
+// bufferingWriter buffers the response if shouldBuffer approves the status & headers at the time the header is written,
+// otherwise passes it through, e.g. for streams
+type bufferingWriter struct {
+	rw           http.ResponseWriter
+	shouldBuffer func(code int, header http.Header) bool
+
+	// headers set by outer middlewares before the wrapped handler runs
+	before http.Header
+
+	decided   bool
+	buffering bool
+	code      int
+	body      bytes.Buffer
+}
+
+func newBufferingWriter(rw http.ResponseWriter, shouldBuffer func(code int, header http.Header) bool) *bufferingWriter {
+	return &bufferingWriter{
+		rw:           rw,
+		shouldBuffer: shouldBuffer,
+		before:       rw.Header().Clone(),
+	}
+}
+
+// writtenHeader returns the headers set by the wrapped handler, leaving out those of outer middlewares,
+// e.g. cors, request id & trace headers, which are bound to the current request
+func (bw *bufferingWriter) writtenHeader() http.Header {
+	written := http.Header{}
+	for k, v := range bw.rw.Header() {
+		if prev := bw.before[k]; len(v) >= len(prev) && stringsEqual(v[:len(prev)], prev) {
+			v = v[len(prev):]
+		}
+
+		if len(v) > 0 {
+			written[k] = append([]string(nil), v...)
+		}
+	}
+
+	return written
+}
+
+// replayHeader sets the recorded headers on dst, merging Vary with the keys set by outer middlewares
+func replayHeader(dst, recorded http.Header) {
+	for k, v := range recorded {
+		if k == varyHeader {
+			for _, vv := range v {
+				for _, key := range strings.Split(vv, ",") {
+					addVary(dst, strings.TrimSpace(key))
+				}
+			}
+
+			continue
+		}
+
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (bw *bufferingWriter) Header() http.Header {
+	return bw.rw.Header()
+}
+
+func (bw *bufferingWriter) WriteHeader(code int) {
+	if bw.decided {
+		return
+	}
+
+	bw.decided = true
+	bw.code = code
+
+	if bw.shouldBuffer(code, bw.rw.Header()) {
+		bw.buffering = true
+		return
+	}
+
+	bw.rw.WriteHeader(code)
+}
+
+func (bw *bufferingWriter) Write(b []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	if bw.buffering {
+		return bw.body.Write(b)
+	}
+
+	return bw.rw.Write(b)
+}
+
+func (bw *bufferingWriter) Flush() {
+	if bw.buffering {
+		return
+	}
+
+	// flushing before writing anything sends the header
+	bw.WriteHeader(http.StatusOK)
+	if f, ok := bw.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// isStreamResponse reports whether the response is a stream written by *ServerStream
+func isStreamResponse(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get(contentTypeHeader))
+	return mediaType == ContentTypeNDJSON || mediaType == ContentTypeEventStream
+}
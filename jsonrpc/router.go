@@ -0,0 +1,305 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ipfs-force-community/common"
+)
+
+const allowHeader = "Allow"
+
+var (
+	ctxKeyPathParams = NewCtxKey("_path_params")
+	ctxKeyRoute      = NewCtxKey("_route")
+)
+
+// PathParam returns the value of the named path parameter, e.g. "id" of the pattern "/orders/{id}",
+// and an empty string if the request matched no such parameter
+func PathParam(req *http.Request, name string) string {
+	params, _ := Extract(req, ctxKeyPathParams).(map[string]string)
+	return params[name]
+}
+
+// RoutePattern returns the full pattern of the route serving the request, e.g. "/v1/orders/{id}",
+// which is available to middlewares as well
+func RoutePattern(req *http.Request) string {
+	if meta := extractRouteMeta(req); meta != nil {
+		return meta.pattern
+	}
+
+	return ""
+}
+
+// routeMeta is the static information of the route serving a request, available to middlewares
+type routeMeta struct {
+	pattern string
+	scope   string
+	perm    common.Perm
+	cache   *CacheControl
+}
+
+func extractRouteMeta(req *http.Request) *routeMeta {
+	meta, _ := Extract(req, ctxKeyRoute).(*routeMeta)
+	return meta
+}
+
+func injectRouteMeta(meta *routeMeta, inner HandlerFunc) HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) error {
+		return inner(rw, Inject(req, ctxKeyRoute, meta))
+	}
+}
+
+// routePattern is a parsed pattern.
+// Segments wrapped in braces, e.g. "{id}", match any single non-empty segment of the path,
+// and a pattern ending with a slash matches the whole subtree below it, like *http.ServeMux does
+type routePattern struct {
+	raw     string
+	segs    []string
+	params  int
+	subtree bool
+}
+
+func parseRoutePattern(raw string) routePattern {
+	p := routePattern{
+		raw:     raw,
+		subtree: strings.HasSuffix(raw, "/"),
+	}
+
+	p.segs = strings.Split(strings.Trim(raw, "/"), "/")
+	for _, seg := range p.segs {
+		if isParamSegment(seg) {
+			p.params++
+		}
+	}
+
+	return p
+}
+
+func isParamSegment(seg string) bool {
+	return len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}'
+}
+
+func (p routePattern) match(path string) (map[string]string, bool) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+
+	if p.subtree {
+		// the pattern "/a/" matches "/a/" and anything below it, but not "/a"
+		if p.raw == "/" {
+			return nil, true
+		}
+
+		if len(segs) < len(p.segs) || (len(segs) == len(p.segs) && !strings.HasSuffix(path, "/")) {
+			return nil, false
+		}
+	} else if len(segs) != len(p.segs) || strings.HasSuffix(path, "/") {
+		return nil, false
+	}
+
+	var params map[string]string
+
+	for i, seg := range p.segs {
+		if isParamSegment(seg) {
+			if segs[i] == "" {
+				return nil, false
+			}
+
+			if params == nil {
+				params = map[string]string{}
+			}
+
+			params[seg[1:len(seg)-1]] = segs[i]
+			continue
+		}
+
+		if seg != segs[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// routeEntry holds routes sharing the same pattern, keyed by http method, and "" for any method
+type routeEntry struct {
+	pattern routePattern
+	methods map[string]route
+	order   int
+}
+
+func (e *routeEntry) allowed() string {
+	methods := make([]string, 0, len(e.methods))
+	for method := range e.methods {
+		methods = append(methods, method)
+	}
+
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// router is the routing table resolved from a mux tree.
+// Exact patterns are preferred, then patterns with parameters, fewer parameters first,
+// and subtree patterns come last, longest first
+type router struct {
+	exact    map[string]*routeEntry
+	patterns []*routeEntry
+
+	// fallback replies 404 & 405, wrapped by middlewares of the root mux
+	fallback HandlerFunc
+	logger   Logger
+}
+
+func newRouter(routes []route) *router {
+	rt := &router{
+		exact: map[string]*routeEntry{},
+	}
+
+	entries := map[string]*routeEntry{}
+
+	for _, r := range routes {
+		entry, ok := entries[r.pattern]
+		if !ok {
+			entry = &routeEntry{
+				pattern: parseRoutePattern(r.pattern),
+				methods: map[string]route{},
+				order:   len(entries),
+			}
+
+			entries[r.pattern] = entry
+
+			if entry.pattern.params == 0 && !entry.pattern.subtree {
+				rt.exact[r.pattern] = entry
+			} else {
+				rt.patterns = append(rt.patterns, entry)
+			}
+		}
+
+		// the latest registration wins, as the routes of sub muxes come after those of their parents
+		entry.methods[r.method] = r
+	}
+
+	sort.SliceStable(rt.patterns, func(i, j int) bool {
+		pi, pj := rt.patterns[i].pattern, rt.patterns[j].pattern
+		if pi.subtree != pj.subtree {
+			return !pi.subtree
+		}
+
+		if pi.subtree && len(pi.segs) != len(pj.segs) {
+			return len(pi.segs) > len(pj.segs)
+		}
+
+		if pi.params != pj.params {
+			return pi.params < pj.params
+		}
+
+		return rt.patterns[i].order < rt.patterns[j].order
+	})
+
+	return rt
+}
+
+// lookup returns the entry matching the given path, along with the path parameters
+func (rt *router) lookup(path string) (*routeEntry, map[string]string) {
+	if entry, ok := rt.exact[path]; ok {
+		return entry, nil
+	}
+
+	for _, entry := range rt.patterns {
+		if params, ok := entry.pattern.match(path); ok {
+			return entry, params
+		}
+	}
+
+	return nil, nil
+}
+
+// match returns the route for the given method & path,
+// with an error replied as 404 if no pattern matches and 405 if no route of the matched pattern accepts the method
+func (rt *router) match(method, path string) (route, map[string]string, error) {
+	entry, params := rt.lookup(path)
+	if entry == nil {
+		return route{}, nil, NewRPCErrorWithCode(http.StatusNotFound)
+	}
+
+	if r, ok := entry.methods[method]; ok {
+		return r, params, nil
+	}
+
+	if r, ok := entry.methods[""]; ok {
+		return r, params, nil
+	}
+
+	// HEAD is served by GET handlers, as *http.ServeMux based servers do
+	if r, ok := entry.methods[http.MethodGet]; ok && method == http.MethodHead {
+		return r, params, nil
+	}
+
+	return route{}, nil, &methodNotAllowed{
+		RPCError: NewRPCErrorWithCode(http.StatusMethodNotAllowed),
+		allow:    entry.allowed(),
+	}
+}
+
+// methodNotAllowed carries the Allow header of a 405 reply
+type methodNotAllowed struct {
+	*RPCError
+	allow string
+}
+
+// matchPreflight returns the route of the method requested by a cors preflight request,
+// unless the matched pattern has a handler for OPTIONS
+func (rt *router) matchPreflight(req *http.Request) (route, map[string]string, bool) {
+	entry, _ := rt.lookup(req.URL.Path)
+	if entry == nil {
+		return route{}, nil, false
+	}
+
+	if _, ok := entry.methods[http.MethodOptions]; ok {
+		return route{}, nil, false
+	}
+
+	r, params, err := rt.match(req.Header.Get(corsHeaderRequestMethod), req.URL.Path)
+	return r, params, err == nil && r.preflight != nil
+}
+
+func (rt *router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if isCORSPreflight(req) {
+		if r, params, ok := rt.matchPreflight(req); ok {
+			req = Inject(req, ctxKeyCORSPreflight, &corsPreflight{})
+			if params != nil {
+				req = Inject(req, ctxKeyPathParams, params)
+			}
+
+			route{handler: r.preflight, logger: r.logger}.httpHandler().ServeHTTP(rw, req)
+			return
+		}
+	}
+
+	r, params, err := rt.match(req.Method, req.URL.Path)
+	if err != nil {
+		if e, ok := err.(*methodNotAllowed); ok {
+			rw.Header().Set(allowHeader, e.allow)
+			err = e.RPCError
+		}
+
+		req = Inject(req, ctxKeyRouteErr, err)
+		route{handler: rt.fallback, logger: rt.logger}.httpHandler().ServeHTTP(rw, req)
+		return
+	}
+
+	if params != nil {
+		req = Inject(req, ctxKeyPathParams, params)
+	}
+
+	r.httpHandler().ServeHTTP(rw, req)
+}
+
+var ctxKeyRouteErr = NewCtxKey("_route_err")
+
+// replyRouteError is the innermost handler of the fallback, returning the routing error to the middlewares,
+// e.g. HandleError
+func replyRouteError(rw http.ResponseWriter, req *http.Request) error {
+	err, _ := Extract(req, ctxKeyRouteErr).(error)
+	return err
+}
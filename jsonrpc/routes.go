@@ -0,0 +1,140 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/ipfs-force-community/common"
+)
+
+// RoutesPath is the path of the route table endpoint registered by RegisterRoutesHandler
+const RoutesPath = "/_routes"
+
+// RouteOption sets extra information of a handler
+type RouteOption func(*patternedHandler)
+
+// WithAccess declares the access scope & the required perm checked by the handler, only for introspection,
+// the check itself is done inside the handler, e.g. by access.CheckAndInjectAccessPerms in generated codes
+func WithAccess(scope string, perm common.Perm) RouteOption {
+	return func(h *patternedHandler) {
+		h.scope = scope
+		h.perm = perm
+	}
+}
+
+// RouteInfo describes a resolved route of a mux tree
+type RouteInfo struct {
+	// Method is the http method served, empty means any
+	Method string
+
+	// Path is the full pattern, including prefixes of all ancestor muxes
+	Path string
+
+	// MuxPrefix is the full prefix of the mux owning the handler
+	MuxPrefix string
+
+	// Middlewares are names of the middlewares wrapping the handler, outermost first
+	Middlewares []string
+
+	Scope string
+	Perm  common.Perm
+}
+
+// Routes returns the resolved route table of the mux tree, in registration order
+func (m *Mux) Routes() []RouteInfo {
+	routes := m.routes("", nil)
+	infos := make([]RouteInfo, 0, len(routes))
+
+	for _, r := range routes {
+		names := make([]string, 0, len(r.mds))
+		for _, md := range r.mds {
+			names = append(names, middlewareName(md))
+		}
+
+		infos = append(infos, RouteInfo{
+			Method:      r.method,
+			Path:        r.pattern,
+			MuxPrefix:   r.muxPrefix,
+			Middlewares: names,
+			Scope:       r.scope,
+			Perm:        r.perm,
+		})
+	}
+
+	return infos
+}
+
+// middlewareName returns the name of the func building the middleware, e.g. "jsonrpc.HandleError"
+func middlewareName(md Middleware) string {
+	f := runtime.FuncForPC(reflect.ValueOf(md).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	// closures are named like "jsonrpc.HandleError.func1" or "jsonrpc.HandleError.func1.2"
+	parts := strings.Split(name, ".")
+	for len(parts) > 2 {
+		last := parts[len(parts)-1]
+		if strings.HasPrefix(last, "func") || strings.Trim(last, "0123456789") == "" {
+			parts = parts[:len(parts)-1]
+			continue
+		}
+
+		break
+	}
+
+	return strings.Join(parts, ".")
+}
+
+type routeInfoJSON struct {
+	Method      string   `json:"method,omitempty"`
+	Path        string   `json:"path"`
+	MuxPrefix   string   `json:"mux_prefix"`
+	Middlewares []string `json:"middlewares"`
+	Scope       string   `json:"scope,omitempty"`
+	Perm        string   `json:"perm,omitempty"`
+}
+
+// RoutesHandler returns a handler replying the route table of the mux tree as json
+func (m *Mux) RoutesHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		infos := m.Routes()
+		out := make([]routeInfoJSON, 0, len(infos))
+		for _, info := range infos {
+			ri := routeInfoJSON{
+				Method:      info.Method,
+				Path:        info.Path,
+				MuxPrefix:   info.MuxPrefix,
+				Middlewares: info.Middlewares,
+				Scope:       info.Scope,
+			}
+
+			if info.Scope != "" {
+				ri.Perm = info.Perm.String()
+			}
+
+			out = append(out, ri)
+		}
+
+		rw.Header().Set(contentTypeHeader, ContentTypeJSON)
+		json.NewEncoder(rw).Encode(out)
+	})
+}
+
+// RegisterRoutesHandler registers the route table endpoint of jmux onto the given std *http.ServeMux at RoutesPath,
+// and uses http.DefaultServeMux if stdmux is nil
+func RegisterRoutesHandler(stdmux *http.ServeMux, jmux *Mux) {
+	if stdmux == nil {
+		stdmux = http.DefaultServeMux
+	}
+
+	stdmux.Handle(RoutesPath, jmux.RoutesHandler())
+}
@@ -3,65 +3,331 @@ package jsonrpc
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/ipfs-force-community/common"
 )
 
+// ClientOption configures an *RPCClient
+type ClientOption func(*RPCClient)
+
+// WithCodec sets the codec used for request bodies and asked for in responses, defaults to JSONCodec
+func WithCodec(c Codec) ClientOption {
+	return func(rc *RPCClient) {
+		if c != nil {
+			rc.codec = c
+		}
+	}
+}
+
+// WithGRPCStatusErrors makes the client return errors carrying a grpc status instead of *RPCError,
+// which can be inspected by status.FromError & status.Code
+func WithGRPCStatusErrors() ClientOption {
+	return func(rc *RPCClient) {
+		rc.grpcStatus = true
+	}
+}
+
 // NewRPCClient 创建 rpc 客户端
-func NewRPCClient(host string, rt *http.Client) *RPCClient {
+func NewRPCClient(host string, rt *http.Client, opts ...ClientOption) *RPCClient {
 	if rt == nil {
 		rt = http.DefaultClient
 	}
 
-	return &RPCClient{
+	rc := &RPCClient{
 		host:    host,
 		httpcli: rt,
+		codec:   JSONCodec,
+	}
+
+	for _, opt := range opts {
+		opt(rc)
 	}
+
+	rc.invoker = chainInterceptors(rc.interceptors, rc.invoke)
+	rc.streamer = chainStreamInterceptors(rc.streamInterceptors, rc.stream)
+	return rc
 }
 
 // RPCClient jsonrpc client based on http1.1
 type RPCClient struct {
 	host    string
 	httpcli *http.Client
+	codec   Codec
+
+	grpcStatus bool
+	retry      RetryPolicy
+	breakers   *circuitBreakers
+	balancer   *balancer
+
+	interceptors       []ClientInterceptor
+	streamInterceptors []StreamClientInterceptor
+	invoker            Invoker
+	streamer           Streamer
 }
 
 // Call calls specified method with given data & response receiver
-func (rc *RPCClient) Call(ctx context.Context, method string, data, recv proto.Message) error {
+func (rc *RPCClient) Call(ctx context.Context, method string, data, recv proto.Message, opts ...CallOption) error {
+	return rc.invoker(ctx, method, data, recv, opts...)
+}
+
+// invoke is the innermost Invoker
+func (rc *RPCClient) invoke(ctx context.Context, method string, data, recv proto.Message, opts ...CallOption) error {
+	co := newCallOptions(ctx, opts)
+	err := rc.withRetry(ctx, co, func() error {
+		host, done := rc.pickHost(co)
+		err := rc.withCircuit(host, method, func() error {
+			return rc.call(ctx, host, method, data, recv, co)
+		})
+
+		done(err)
+		return err
+	})
+
+	return rc.convertError(err)
+}
+
+func (rc *RPCClient) call(ctx context.Context, host, method string, data, recv proto.Message, co callOptions) error {
+	req, err := rc.newRequest(ctx, host, method, data, co)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(acceptHeader, rc.codec.ContentType())
+
+	resp, err := rc.httpcli.Do(req)
+	if err != nil {
+		return sendError(ctx, err)
+	}
+
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, rc.codec, recv)
+}
+
+// Stream calls specified server-streaming method with given data, messages are read from the returned *StreamReader,
+// which should be closed by the caller. Retries only apply to the opening of the stream
+func (rc *RPCClient) Stream(ctx context.Context, method string, data proto.Message, opts ...CallOption) (*StreamReader, error) {
+	return rc.streamer(ctx, method, data, opts...)
+}
+
+// stream is the innermost Streamer
+func (rc *RPCClient) stream(ctx context.Context, method string, data proto.Message, opts ...CallOption) (*StreamReader, error) {
+	var sr *StreamReader
+
+	co := newCallOptions(ctx, opts)
+	err := rc.withRetry(ctx, co, func() error {
+		host, done := rc.pickHost(co)
+		err := rc.withCircuit(host, method, func() error {
+			req, err := rc.newRequest(ctx, host, method, data, co)
+			if err != nil {
+				return err
+			}
+
+			req.Header.Set(acceptHeader, ContentTypeNDJSON)
+
+			resp, err := rc.httpcli.Do(req)
+			if err != nil {
+				return sendError(ctx, err)
+			}
+
+			sr, err = newStreamReader(resp)
+			return err
+		})
+
+		// only the opening of the stream counts for the balancer
+		done(err)
+		return err
+	})
+
+	if err != nil {
+		return nil, rc.convertError(err)
+	}
+
+	sr.grpcStatus = rc.grpcStatus
+	return sr, nil
+}
+
+// pickHost returns the host of an attempt, the returned func must be called with the result of the attempt
+func (rc *RPCClient) pickHost(co callOptions) (string, func(error)) {
+	if rc.balancer == nil {
+		return rc.host, func(error) {}
+	}
+
+	return rc.balancer.pick(co)
+}
+
+// sendError converts the error of sending a request, failures caused by ctx are returned as *RPCError with 504 or 499
+func sendError(ctx context.Context, err error) error {
+	if ctx != nil && ctx.Err() != nil {
+		return ToRPCError(ctx.Err())
+	}
+
+	return &sendFailure{err: err}
+}
+
+// sendFailure is an error occurred before any response is received
+type sendFailure struct {
+	err error
+}
+
+func (e *sendFailure) Error() string {
+	return fmt.Sprintf("unable to send http post request, err=%v", e.err)
+}
+
+// convertError converts *RPCError into a grpc status error if asked to
+func (rc *RPCClient) convertError(err error) error {
+	if e, ok := err.(*RPCError); ok && rc.grpcStatus {
+		return e.GRPCStatus().Err()
+	}
+
+	return err
+}
+
+func (rc *RPCClient) newRequest(ctx context.Context, host, method string, data proto.Message, co callOptions) (*http.Request, error) {
 	var reqBody io.Reader
 
 	if data != nil {
 		buf := bytes.NewBufferString("")
-		if err := EncodeJSON(buf, data); err != nil {
-			return fmt.Errorf("unable to marshal request, err=%v", err)
+		if err := rc.codec.Encode(buf, data); err != nil {
+			return nil, fmt.Errorf("unable to marshal request, err=%v", err)
 		}
 
 		reqBody = buf
 	}
 
-	req, err := http.NewRequest(http.MethodPost, rc.host+method, reqBody)
+	req, err := http.NewRequest(http.MethodPost, host+method, reqBody)
 	if err != nil {
-		return fmt.Errorf("unable to build http request, err=%v", err)
+		return nil, fmt.Errorf("unable to build http request, err=%v", err)
+	}
+
+	for key, values := range co.header {
+		req.Header[key] = values
 	}
 
+	req.Header.Set(contentTypeHeader, rc.codec.ContentType())
+
 	if ctx != nil {
 		req = req.WithContext(ctx)
+		setTimeoutHeader(ctx, req.Header)
+		injectTraceContext(ctx, req.Header)
+
+		// forward the id of the request being served, unless set by WithHeader
+		if reqID, ok := RequestIDFromContext(ctx); ok && req.Header.Get(RequestIDHeader) == "" {
+			req.Header.Set(RequestIDHeader, reqID)
+		}
 	}
 
-	resp, err := rc.httpcli.Do(req)
+	return req, nil
+}
+
+// decodeResponse decodes the response body into recv, error responses are converted into *RPCError
+func decodeResponse(resp *http.Response, fallback Codec, recv proto.Message) error {
+	codec, ok := LookupCodec(resp.Header.Get(contentTypeHeader))
+	if !ok {
+		codec = fallback
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("unable to send http post request, err=%v", err)
+		return fmt.Errorf("unable to read response body, err=%v", err)
 	}
 
-	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices || resp.Header.Get(ResultCodeHeader) != "" {
+		return errorFromResponse(resp, codec, body)
+	}
+
+	if recv == nil {
+		return nil
+	}
 
-	if recv != nil {
-		if err := DecodeJSON(resp.Body, recv); err != nil {
-			return fmt.Errorf("unable to unmarshal response body, err=%v", err)
+	if err := codec.Decode(bytes.NewReader(body), recv); err != nil {
+		return fmt.Errorf("unable to unmarshal response body, err=%v", err)
+	}
+
+	// responses carrying a common.Result report failures through it
+	if withRes, ok := recv.(interface{ GetRes() *common.Result }); ok {
+		if res := withRes.GetRes(); res != nil && !IsSuccessCode(int(res.Code)) {
+			return &RPCError{
+				Code:  int(res.Code),
+				Msg:   res.Msg,
+				ReqID: resp.Header.Get(RequestIDHeader),
+			}
+		}
+
+		return nil
+	}
+
+	// servers without ResultCodeHeader write error envelopes with a success status
+	if codec == JSONCodec {
+		simple := &common.SimpleResp{}
+		if DecodeJSON(bytes.NewReader(body), simple) == nil && simple.Res != nil && !IsSuccessCode(int(simple.Res.Code)) {
+			return errorFromResponse(resp, codec, body)
 		}
 	}
 
 	return nil
 }
+
+// errorFromResponse builds an *RPCError from the status & the error envelope written by HandleError
+func errorFromResponse(resp *http.Response, codec Codec, body []byte) *RPCError {
+	e := &RPCError{
+		Code:  resp.StatusCode,
+		Msg:   http.StatusText(resp.StatusCode),
+		ReqID: resp.Header.Get(RequestIDHeader),
+	}
+
+	if raw := resp.Header.Get(ErrorDetailsHeader); raw != "" {
+		d := &errorDetails{}
+		if err := json.Unmarshal([]byte(raw), d); err == nil {
+			e.setDetails(d)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(contentTypeHeader))
+
+	switch {
+	case mediaType == ContentTypeProblemJSON:
+		p := &problem{errorData: &errorData{errorDetails: &errorDetails{}}}
+		if err := json.Unmarshal(body, p); err == nil {
+			e.Code = p.Code
+			e.Msg = p.Detail
+			e.setDetails(p.errorDetails)
+		}
+
+		return e
+
+	case codec == JSONCodec && bytes.Contains(body, []byte(`"jsonrpc"`)):
+		r := &jsonrpc2Response{}
+		if err := json.Unmarshal(body, r); err == nil && r.Error != nil {
+			e.Code = r.Error.Code
+			e.Msg = r.Error.Message
+
+			if raw, err := json.Marshal(r.Error.Data); err == nil {
+				d := &errorDetails{}
+				if json.Unmarshal(raw, d) == nil {
+					e.setDetails(d)
+				}
+			}
+
+			return e
+		}
+	}
+
+	simple := &common.SimpleResp{}
+	if err := codec.Decode(bytes.NewReader(body), simple); err == nil && simple.Res != nil {
+		e.Code = int(simple.Res.Code)
+		e.Msg = simple.Res.Msg
+	} else if len(body) > 0 && e.Code >= http.StatusMultipleChoices {
+		e.Msg = strings.TrimSpace(string(body))
+	}
+
+	return e
+}
@@ -0,0 +1,319 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs-force-community/gosf/metric"
+	"github.com/ipfs-force-community/gosf/proc"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	metric.Collect(circuitStateMetric, circuitRejectedMetric)
+}
+
+var (
+	circuitStateMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "service",
+			Subsystem: proc.AppName(),
+			Name:      "circuit_state",
+			Help:      "0 for closed, 1 for half-open, 2 for open",
+		},
+		[]string{"host", "method"},
+	)
+
+	circuitRejectedMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "service",
+			Subsystem: proc.AppName(),
+			Name:      "circuit_rejected_total",
+		},
+		[]string{"host", "method"},
+	)
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+// states of a circuit breaker
+const (
+	CircuitClosed CircuitState = iota
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+
+	case CircuitHalfOpen:
+		return "half-open"
+
+	case CircuitOpen:
+		return "open"
+	}
+
+	return "unknown"
+}
+
+// DefaultCircuitBreakerConfig default circuit breaker config
+var DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+	FailureThreshold: 5,
+	Cooldown:         10 * time.Second,
+	HalfOpenProbes:   1,
+}
+
+// CircuitBreakerConfig configures the circuit breaker of *RPCClient.
+//
+// A closed circuit opens after FailureThreshold consecutive failures, i.e. transport errors
+// and *RPCError with 5xx codes. Calls on an open circuit fail at once with *CircuitOpenError.
+// After Cooldown the circuit becomes half-open and lets HalfOpenProbes calls through concurrently,
+// which close the circuit if all of them succeed, and open it again on any failure.
+type CircuitBreakerConfig struct {
+	// PerMethod keeps a circuit for each method instead of one for the whole host
+	PerMethod bool
+
+	FailureThreshold int
+	Cooldown         time.Duration
+	HalfOpenProbes   int
+
+	// Logger logs state changes, defaults to zap.S()
+	Logger Logger
+}
+
+// WithCircuitBreaker enables the circuit breaker of the client, see CircuitBreakerConfig
+func WithCircuitBreaker(cfg CircuitBreakerConfig) ClientOption {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultCircuitBreakerConfig.FailureThreshold
+	}
+
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultCircuitBreakerConfig.Cooldown
+	}
+
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = DefaultCircuitBreakerConfig.HalfOpenProbes
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = stdLogger
+	}
+
+	return func(rc *RPCClient) {
+		rc.breakers = &circuitBreakers{
+			cfg:      cfg,
+			circuits: map[string]*circuit{},
+		}
+	}
+}
+
+// CircuitOpenError is returned without sending the request while the circuit is open
+type CircuitOpenError struct {
+	Host   string
+	Method string
+
+	// RetryAfter is the remaining cooldown before the circuit lets probes through
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open: host=%s, method=%s, retry_after=%s", e.Host, e.Method, e.RetryAfter)
+}
+
+// GRPCStatus reports the error as codes.Unavailable
+func (e *CircuitOpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+// IsCircuitOpen reports whether err is a fast failure of an open circuit
+func IsCircuitOpen(err error) bool {
+	_, ok := err.(*CircuitOpenError)
+	return ok
+}
+
+type circuitBreakers struct {
+	cfg CircuitBreakerConfig
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+func (cbs *circuitBreakers) get(host, method string) *circuit {
+	if !cbs.cfg.PerMethod {
+		method = ""
+	}
+
+	key := host + " " + method
+
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+
+	c, ok := cbs.circuits[key]
+	if !ok {
+		labels := prometheus.Labels{"host": host, "method": method}
+		c = &circuit{
+			cfg:      cbs.cfg,
+			host:     host,
+			method:   method,
+			gauge:    circuitStateMetric.With(labels),
+			rejected: circuitRejectedMetric.With(labels),
+		}
+
+		c.gauge.Set(float64(CircuitClosed))
+		cbs.circuits[key] = c
+	}
+
+	return c
+}
+
+type circuit struct {
+	cfg    CircuitBreakerConfig
+	host   string
+	method string
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+
+	gauge    prometheus.Gauge
+	rejected prometheus.Counter
+}
+
+// allow returns an error if the call should fail fast, otherwise the result of the call must be reported by done
+func (c *circuit) allow(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitOpen {
+		if wait := c.cfg.Cooldown - now.Sub(c.openedAt); wait > 0 {
+			c.rejected.Inc()
+			return &CircuitOpenError{Host: c.host, Method: c.method, RetryAfter: wait}
+		}
+
+		c.setState(CircuitHalfOpen)
+	}
+
+	if c.state == CircuitHalfOpen {
+		if c.probes >= c.cfg.HalfOpenProbes {
+			c.rejected.Inc()
+			return &CircuitOpenError{Host: c.host, Method: c.method}
+		}
+
+		c.probes++
+	}
+
+	return nil
+}
+
+func (c *circuit) done(err error, now time.Time) {
+	outcome := circuitOutcomeOf(err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case CircuitClosed:
+		switch outcome {
+		case circuitSuccess:
+			c.failures = 0
+
+		case circuitFailure:
+			c.failures++
+			if c.failures >= c.cfg.FailureThreshold {
+				c.open(now)
+			}
+		}
+
+	case CircuitHalfOpen:
+		switch outcome {
+		case circuitSuccess:
+			c.successes++
+			if c.successes >= c.cfg.HalfOpenProbes {
+				c.setState(CircuitClosed)
+			}
+
+		case circuitFailure:
+			c.open(now)
+
+		default:
+			// the probe tells nothing, give its slot to another call
+			c.probes--
+		}
+	}
+}
+
+func (c *circuit) open(now time.Time) {
+	c.openedAt = now
+	c.setState(CircuitOpen)
+}
+
+func (c *circuit) setState(s CircuitState) {
+	if c.state != s {
+		c.cfg.Logger.Warnf("circuit state changed, host=%s, method=%s, from=%s, to=%s, failures=%d", c.host, c.method, c.state, s, c.failures)
+	}
+
+	c.state = s
+	c.failures = 0
+	c.probes = 0
+	c.successes = 0
+	c.gauge.Set(float64(s))
+}
+
+type circuitOutcome int
+
+const (
+	circuitNeutral circuitOutcome = iota
+	circuitSuccess
+	circuitFailure
+)
+
+// circuitOutcomeOf tells whether err indicates a healthy or an unhealthy remote,
+// calls canceled by the caller & local errors, e.g. failing to encode the request, are neutral
+func circuitOutcomeOf(err error) circuitOutcome {
+	switch e := err.(type) {
+	case nil:
+		return circuitSuccess
+
+	case *sendFailure:
+		return circuitFailure
+
+	case *RPCError:
+		if e.Code == statusClientClosedRequest {
+			return circuitNeutral
+		}
+
+		if e.Code >= http.StatusInternalServerError && e.Code < 600 {
+			return circuitFailure
+		}
+
+		return circuitSuccess
+	}
+
+	return circuitNeutral
+}
+
+// withCircuit runs attempt through the circuit of the host & method if the breaker is enabled
+func (rc *RPCClient) withCircuit(host, method string, attempt func() error) error {
+	if rc.breakers == nil {
+		return attempt()
+	}
+
+	c := rc.breakers.get(host, method)
+	if err := c.allow(time.Now()); err != nil {
+		return err
+	}
+
+	err := attempt()
+	c.done(err, time.Now())
+	return err
+}
@@ -0,0 +1,92 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCClientCircuitBreaker(t *testing.T) {
+	var (
+		healthy int32
+		calls   int32
+	)
+
+	mux := NewMux("", nil, HandleError())
+	mux.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			return NewRPCErrorWithCode(http.StatusInternalServerError)
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, "ok")})
+	})
+
+	mux.Handle("/BadRequest", func(rw http.ResponseWriter, req *http.Request) error {
+		return NewRPCErrorWithCode(http.StatusBadRequest)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cli := NewRPCClient(srv.URL, nil, WithCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 3,
+		Cooldown:         50 * time.Millisecond,
+	}))
+
+	call := func(method string) error {
+		return cli.Call(context.Background(), method, common.EMPTY, &common.SimpleResp{})
+	}
+
+	// client errors do not count as failures
+	for i := 0; i < 5; i++ {
+		if err := call("/BadRequest"); IsCircuitOpen(err) {
+			t.Fatal("circuit opened by 4xx")
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := call("/Call"); err == nil || IsCircuitOpen(err) {
+			t.Fatalf("expected remote error, got %v", err)
+		}
+	}
+
+	err := call("/Call")
+	if !IsCircuitOpen(err) {
+		t.Fatalf("expected circuit open, got %v", err)
+	}
+
+	if s, _ := status.FromError(err); s.Code() != codes.Unavailable {
+		t.Fatalf("expected unavailable status, got %s", s.Code())
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("expected fast failure without sending, got %d calls", n)
+	}
+
+	// a failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	if err := call("/Call"); err == nil || IsCircuitOpen(err) {
+		t.Fatalf("expected probe sent, got %v", err)
+	}
+
+	if err := call("/Call"); !IsCircuitOpen(err) {
+		t.Fatalf("expected circuit open after failed probe, got %v", err)
+	}
+
+	// a successful probe closes it
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := call("/Call"); err != nil {
+			t.Fatalf("expected circuit closed, got %v", err)
+		}
+	}
+}
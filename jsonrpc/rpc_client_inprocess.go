@@ -0,0 +1,149 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const inProcessHost = "http://in-process"
+
+// NewInProcessRPCClient creates an rpc client dispatching calls directly into h, e.g. a *Mux,
+// without opening any socket. Requests still go through the codecs & the middlewares of h
+func NewInProcessRPCClient(h http.Handler, opts ...ClientOption) *RPCClient {
+	return NewRPCClient(inProcessHost, &http.Client{Transport: InProcessTransport(h)}, opts...)
+}
+
+// InProcessTransport returns an http.RoundTripper serving requests by h in the calling process.
+// Response bodies are streamed through a pipe, so that streaming methods work as over the network,
+// while hijacking connections, e.g. for websocket, is not supported
+func InProcessTransport(h http.Handler) http.RoundTripper {
+	return &inProcessTransport{handler: h}
+}
+
+type inProcessTransport struct {
+	handler http.Handler
+}
+
+func (t *inProcessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sreq := req.Clone(req.Context())
+	sreq.RequestURI = req.URL.RequestURI()
+	sreq.RemoteAddr = "in-process"
+	sreq.Proto, sreq.ProtoMajor, sreq.ProtoMinor = "HTTP/1.1", 1, 1
+	if sreq.Body == nil {
+		sreq.Body = http.NoBody
+	}
+
+	pr, pw := io.Pipe()
+	rw := &pipeResponseWriter{
+		header:  http.Header{},
+		trailer: http.Header{},
+		pw:      pw,
+		ready:   make(chan struct{}),
+	}
+
+	done := make(chan interface{}, 1)
+	go func() {
+		defer sreq.Body.Close()
+
+		defer func() {
+			p := recover()
+			if p != nil {
+				pw.CloseWithError(fmt.Errorf("handler panic: %v", p))
+			} else {
+				rw.finish()
+				pw.Close()
+			}
+
+			done <- p
+		}()
+
+		t.handler.ServeHTTP(rw, sreq)
+	}()
+
+	select {
+	case <-rw.ready:
+
+	case p := <-done:
+		if p != nil {
+			return nil, fmt.Errorf("handler panic: %v", p)
+		}
+
+		// the handler returned without writing anything
+		<-rw.ready
+
+	case <-req.Context().Done():
+		pr.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rw.status, http.StatusText(rw.status)),
+		StatusCode:    rw.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rw.sent,
+		Trailer:       rw.trailer,
+		Body:          pr,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// pipeResponseWriter writes the response body into a pipe read by the client
+type pipeResponseWriter struct {
+	header  http.Header
+	trailer http.Header
+	pw      *io.PipeWriter
+
+	once   sync.Once
+	ready  chan struct{}
+	status int
+	sent   http.Header
+}
+
+func (rw *pipeResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *pipeResponseWriter) WriteHeader(code int) {
+	rw.once.Do(func() {
+		rw.status = code
+		rw.sent = rw.header.Clone()
+		close(rw.ready)
+	})
+}
+
+func (rw *pipeResponseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.pw.Write(b)
+}
+
+// Flush is a no-op besides sending the header, writes are passed to the client synchronously
+func (rw *pipeResponseWriter) Flush() {
+	rw.WriteHeader(http.StatusOK)
+}
+
+// finish sends the header if not yet, and collects trailers set by the handler,
+// which are visible to the client after reading the body to the end
+func (rw *pipeResponseWriter) finish() {
+	rw.WriteHeader(http.StatusOK)
+
+	for k, v := range rw.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			rw.trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+
+	for _, k := range rw.sent["Trailer"] {
+		for _, kk := range strings.Split(k, ",") {
+			kk = http.CanonicalHeaderKey(strings.TrimSpace(kk))
+			if v, ok := rw.header[kk]; ok {
+				rw.trailer[kk] = v
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package jsonrpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestInProcessRPCClient(t *testing.T) {
+	mux := NewMux("/v1", nil, InjectRequestID(), HandleError())
+	mux.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		in := &common.SimpleResp{}
+		if err := DecodeRequest(req, in); err != nil {
+			return err
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, in.Res.Msg)})
+	})
+
+	mux.Handle("/Fail", func(rw http.ResponseWriter, req *http.Request) error {
+		return NewRPCErrorWithCode(http.StatusConflict, "conflict")
+	})
+
+	mux.Handle("/Stream", func(rw http.ResponseWriter, req *http.Request) error {
+		stream := NewServerStream(rw, req)
+		for i := 0; i < 3; i++ {
+			if err := stream.SendMsg(&common.SimpleResp{Res: common.NewResult(int32(i), "")}); err != nil {
+				return stream.Finish(err)
+			}
+		}
+
+		return stream.Finish(NewRPCErrorWithCode(http.StatusGone, "gone"))
+	})
+
+	for _, codec := range []Codec{JSONCodec, ProtobufCodec} {
+		cli := NewInProcessRPCClient(mux, WithCodec(codec))
+
+		out := &common.SimpleResp{}
+		if err := cli.Call(context.Background(), "/v1/Call", &common.SimpleResp{Res: common.NewResult(0, "hello")}, out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.Res.Msg != "hello" {
+			t.Fatalf("unexpected response %v", out)
+		}
+
+		err := cli.Call(context.Background(), "/v1/Fail", common.EMPTY, &common.SimpleResp{})
+		if e, ok := err.(*RPCError); !ok || e.Code != http.StatusConflict || e.ReqID == "" {
+			t.Fatalf("expected *RPCError with request id, got %v", err)
+		}
+	}
+
+	cli := NewInProcessRPCClient(mux)
+	sr, err := cli.Stream(context.Background(), "/v1/Stream", common.EMPTY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sr.Close()
+
+	for i := 0; i < 3; i++ {
+		msg := &common.SimpleResp{}
+		if err := sr.Recv(msg); err != nil || msg.Res.Code != int32(i) {
+			t.Fatalf("unexpected message %v, err=%v", msg, err)
+		}
+	}
+
+	if err := sr.Recv(&common.SimpleResp{}); err == io.EOF || ToRPCError(err).Code != http.StatusGone {
+		t.Fatalf("expected stream error, got %v", err)
+	}
+}
+
+func TestStreamErrorWithProtobufCodec(t *testing.T) {
+	mux := NewMux("/v1", nil, InjectRequestID(), HandleError())
+	mux.Handle("/Stream", func(rw http.ResponseWriter, req *http.Request) error {
+		return NewRPCErrorWithCode(http.StatusForbidden, "forbidden").WithReason("NO_PERM")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/Stream", nil)
+	req.Header.Set(contentTypeHeader, ProtobufCodec.ContentType())
+	req.Header.Set(acceptHeader, ContentTypeNDJSON)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if ct := rec.Header().Get(contentTypeHeader); ct != ContentTypeJSON {
+		t.Fatalf("expected the rejection of the stream in json, got %q", ct)
+	}
+
+	cli := NewInProcessRPCClient(mux, WithCodec(ProtobufCodec))
+	_, err := cli.Stream(context.Background(), "/v1/Stream", common.EMPTY)
+	if e, ok := err.(*RPCError); !ok || e.Code != http.StatusForbidden || e.Msg != "forbidden" || e.Reason != "NO_PERM" {
+		t.Fatalf("expected the rejection of the stream, got %#v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package jsonrpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Invoker sends a call, including retries & the circuit breaker of the client
+type Invoker func(ctx context.Context, method string, req, resp proto.Message, opts ...CallOption) error
+
+// ClientInterceptor intercepts calls of *RPCClient, it may inspect or modify ctx, the messages & options,
+// and must call invoker to proceed, e.g. with WithHeader appended to opts to send extra headers
+type ClientInterceptor func(ctx context.Context, method string, req, resp proto.Message, invoker Invoker, opts ...CallOption) error
+
+// Streamer opens a server stream, including retries & the circuit breaker of the client
+type Streamer func(ctx context.Context, method string, req proto.Message, opts ...CallOption) (*StreamReader, error)
+
+// StreamClientInterceptor intercepts the opening of server streams of *RPCClient
+type StreamClientInterceptor func(ctx context.Context, method string, req proto.Message, streamer Streamer, opts ...CallOption) (*StreamReader, error)
+
+// WithInterceptors appends interceptors of Call, the first one is the outermost
+func WithInterceptors(interceptors ...ClientInterceptor) ClientOption {
+	return func(rc *RPCClient) {
+		rc.interceptors = append(rc.interceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors appends interceptors of Stream, the first one is the outermost
+func WithStreamInterceptors(interceptors ...StreamClientInterceptor) ClientOption {
+	return func(rc *RPCClient) {
+		rc.streamInterceptors = append(rc.streamInterceptors, interceptors...)
+	}
+}
+
+func chainInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, req, resp proto.Message, opts ...CallOption) error {
+			return interceptor(ctx, method, req, resp, next, opts...)
+		}
+	}
+
+	return invoker
+}
+
+func chainStreamInterceptors(interceptors []StreamClientInterceptor, streamer Streamer) Streamer {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], streamer
+		streamer = func(ctx context.Context, method string, req proto.Message, opts ...CallOption) (*StreamReader, error) {
+			return interceptor(ctx, method, req, next, opts...)
+		}
+	}
+
+	return streamer
+}
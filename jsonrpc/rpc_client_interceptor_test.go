@@ -0,0 +1,71 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfs-force-community/common"
+)
+
+func TestRPCClientInterceptors(t *testing.T) {
+	mux := NewMux("", nil, HandleError())
+	mux.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		if req.Header.Get(authorizationHeader) != "token" {
+			return NewRPCErrorWithCode(http.StatusUnauthorized)
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, "ok")})
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var trace []string
+	record := func(name string) ClientInterceptor {
+		return func(ctx context.Context, method string, req, resp proto.Message, invoker Invoker, opts ...CallOption) error {
+			trace = append(trace, name+" "+method)
+			err := invoker(ctx, method, req, resp, opts...)
+			if err != nil {
+				trace = append(trace, name+" "+err.Error())
+			}
+
+			return err
+		}
+	}
+
+	auth := func(ctx context.Context, method string, req, resp proto.Message, invoker Invoker, opts ...CallOption) error {
+		if method == "/Call" {
+			opts = append(opts, WithHeader(authorizationHeader, "token"))
+		}
+
+		return invoker(ctx, method, req, resp, opts...)
+	}
+
+	cli := NewRPCClient(srv.URL, nil, WithInterceptors(record("outer"), auth), WithInterceptors(record("inner")))
+
+	resp := &common.SimpleResp{}
+	if err := cli.Call(context.Background(), "/Call", common.EMPTY, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Res.Msg != "ok" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+
+	if err := cli.Call(context.Background(), "/Missing", common.EMPTY, &common.SimpleResp{}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if len(trace) != 6 {
+		t.Fatalf("unexpected trace %q", trace)
+	}
+
+	order := []string{"outer /Call", "inner /Call", "outer /Missing", "inner /Missing"}
+	if !reflect.DeepEqual(trace[:4], order) || trace[4][:6] != "inner " || trace[5][:6] != "outer " {
+		t.Fatalf("unexpected trace %q", trace)
+	}
+}
@@ -0,0 +1,208 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// CallOption configures a single call of *RPCClient
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	idempotent bool
+	header     http.Header
+	balanceKey string
+}
+
+var ctxKeyIdempotencyKey = NewCtxKey("_idempotency_key")
+
+func newCallOptions(ctx context.Context, opts []CallOption) callOptions {
+	co := callOptions{}
+	if ctx != nil {
+		if holder, _ := ctx.Value(ctxKeyIdempotencyKey).(*idempotencyKeyHolder); holder != nil && holder.claim() {
+			IdempotencyKey(holder.key)(&co)
+		}
+	}
+
+	for _, opt := range opts {
+		opt(&co)
+	}
+
+	return co
+}
+
+// Idempotent marks the call as safe to repeat, which is retried by the RetryPolicy of the client,
+// generated clients mark methods declared with the idempotency_level option
+func Idempotent() CallOption {
+	return func(co *callOptions) {
+		co.idempotent = true
+	}
+}
+
+// IdempotencyKey sends the key in IdempotencyKeyHeader and marks the call as Idempotent,
+// which is safe to retry for methods served behind HandleIdempotency, even if they are not idempotent by themselves
+func IdempotencyKey(key string) CallOption {
+	return func(co *callOptions) {
+		WithHeader(IdempotencyKeyHeader, key)(co)
+		co.idempotent = true
+	}
+}
+
+// ContextWithIdempotencyKey returns a context making the first call with it carry the key as IdempotencyKey does,
+// e.g. for calls through generated clients, retries included.
+//
+// The key is bound to that single call: later calls with the context are sent without it, as a key reused
+// by a different call would be replayed the response of the first one, or rejected.
+// Use a new context with another key for each call to be made idempotent
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, ctxKeyIdempotencyKey, &idempotencyKeyHolder{key: key})
+}
+
+// idempotencyKeyHolder makes the key of ContextWithIdempotencyKey used by a single call
+type idempotencyKeyHolder struct {
+	key     string
+	claimed int32
+}
+
+func (h *idempotencyKeyHolder) claim() bool {
+	return h.key != "" && atomic.CompareAndSwapInt32(&h.claimed, 0, 1)
+}
+
+// WithHeader sends an extra http header with the call, e.g. set by a ClientInterceptor
+func WithHeader(key, value string) CallOption {
+	return func(co *callOptions) {
+		if co.header == nil {
+			co.header = http.Header{}
+		}
+
+		co.header.Set(key, value)
+	}
+}
+
+// DefaultRetryPolicy default retry policy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []int{429, 502, 503},
+}
+
+// RetryPolicy decides whether & when failed calls are attempted again.
+//
+// Idempotent calls are retried on transport errors and on *RPCError with RetryableCodes.
+// Other calls are only retried if the request surely did not reach any handler,
+// i.e. the connection could not be established, or the server rejected it by HandleRateLimit
+// or HandleConcurrencyLimit. Calls rejected by HandleIdempotency as duplicates of an in-flight request
+// are retried regardless of RetryableCodes.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, values below 2 disable retries
+	MaxAttempts int
+
+	// the n-th retry waits InitialBackoff * Multiplier^(n-1), capped by MaxBackoff,
+	// and randomized by ±Jitter, e.g. 0.2 for ±20%
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+
+	// RetryableCodes are codes of *RPCError worth a retry, a RetryAfter replied by the server
+	// takes precedence over the backoff
+	RetryableCodes []int
+}
+
+// WithRetryPolicy enables retries of the client, see RetryPolicy
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(rc *RPCClient) {
+		rc.retry = p
+	}
+}
+
+func (p RetryPolicy) retryable(err error, idempotent bool) bool {
+	switch e := err.(type) {
+	case *sendFailure:
+		return idempotent || isDialError(e.err)
+
+	case *RPCError:
+		if e.Reason == ReasonIdempotencyInFlight {
+			return true
+		}
+
+		retryableCode := false
+		for _, code := range p.RetryableCodes {
+			if e.Code == code {
+				retryableCode = true
+				break
+			}
+		}
+
+		return retryableCode && (idempotent || e.Reason == ReasonRateLimited || e.Reason == ReasonOverloaded)
+	}
+
+	return false
+}
+
+// backoff returns the wait before the given retry, starting from 1
+func (p RetryPolicy) backoff(retry int, err error) time.Duration {
+	if e, ok := err.(*RPCError); ok && e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(d)
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// withRetry runs attempt until it succeeds, or the error is not retryable, or attempts are used up,
+// or the next attempt would start after the deadline of ctx
+func (rc *RPCClient) withRetry(ctx context.Context, co callOptions, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n >= rc.retry.MaxAttempts || !rc.retry.retryable(err, co.idempotent) {
+			return err
+		}
+
+		wait := rc.retry.backoff(n, err)
+		if ctx == nil {
+			time.Sleep(wait)
+			continue
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestRPCClientRetry(t *testing.T) {
+	var attempts int32
+
+	mux := NewMux("", nil, HandleError())
+	mux.Handle("/Flaky", func(rw http.ResponseWriter, req *http.Request) error {
+		if atomic.AddInt32(&attempts, 1)%3 != 0 {
+			return NewRPCErrorWithCode(http.StatusServiceUnavailable)
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, "ok")})
+	})
+
+	mux.Handle("/Overloaded", func(rw http.ResponseWriter, req *http.Request) error {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return NewRPCErrorWithCode(http.StatusServiceUnavailable).WithReason(ReasonOverloaded)
+		}
+
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(0, "ok")})
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	policy := DefaultRetryPolicy
+	policy.InitialBackoff = time.Millisecond
+	cli := NewRPCClient(srv.URL, nil, WithRetryPolicy(policy))
+
+	cases := []struct {
+		method   string
+		opts     []CallOption
+		ok       bool
+		attempts int32
+	}{
+		{"/Flaky", []CallOption{Idempotent()}, true, 3},
+		{"/Flaky", nil, false, 1},
+		{"/Overloaded", nil, true, 2},
+	}
+
+	for _, c := range cases {
+		atomic.StoreInt32(&attempts, 0)
+
+		err := cli.Call(context.Background(), c.method, common.EMPTY, &common.SimpleResp{}, c.opts...)
+		if (err == nil) != c.ok || atomic.LoadInt32(&attempts) != c.attempts {
+			t.Fatalf("%s %d opts: expected ok=%v after %d attempts, got err=%v after %d", c.method, len(c.opts), c.ok, c.attempts, err, attempts)
+		}
+	}
+
+	// connection failures are retried for any method
+	srv.Close()
+	atomic.StoreInt32(&attempts, 0)
+
+	start := time.Now()
+	err := cli.Call(context.Background(), "/Flaky", common.EMPTY, &common.SimpleResp{})
+	if _, ok := err.(*sendFailure); !ok {
+		t.Fatalf("expected send failure, got %v", err)
+	}
+
+	// 2 retries waiting 1ms & 2ms, at least 2.4ms after jitter of ±20%
+	if d := time.Since(start); d < 2*time.Millisecond {
+		t.Fatalf("expected backoff between attempts, took %s", d)
+	}
+}
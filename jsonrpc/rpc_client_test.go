@@ -0,0 +1,105 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ipfs-force-community/common"
+)
+
+func TestRPCClientError(t *testing.T) {
+	mux := NewMux("", nil, InjectRequestID(), HandleError())
+	mux.Handle("/Fail", func(rw http.ResponseWriter, req *http.Request) error {
+		return NewRPCErrorWithCode(http.StatusForbidden, "forbidden")
+	})
+
+	mux.Handle("/Res", func(rw http.ResponseWriter, req *http.Request) error {
+		return EncodeResponseFor(rw, req, &common.SimpleResp{Res: common.NewResult(int32(http.StatusConflict), "conflict")})
+	})
+
+	mux.Handle("/Status", func(rw http.ResponseWriter, req *http.Request) error {
+		rw.WriteHeader(http.StatusBadGateway)
+		return nil
+	})
+
+	stdmux := http.NewServeMux()
+	mux.register(stdmux)
+
+	srv := httptest.NewServer(stdmux)
+	defer srv.Close()
+
+	cases := []struct {
+		method string
+		code   int
+		msg    string
+	}{
+		{"/Fail", http.StatusForbidden, "forbidden"},
+		{"/Res", http.StatusConflict, "conflict"},
+		{"/Status", http.StatusBadGateway, http.StatusText(http.StatusBadGateway)},
+	}
+
+	for _, codec := range []Codec{JSONCodec, ProtobufCodec} {
+		cli := NewRPCClient(srv.URL, nil, WithCodec(codec))
+
+		for _, c := range cases {
+			err := cli.Call(context.Background(), c.method, common.EMPTY, &common.SimpleResp{})
+			e, ok := err.(*RPCError)
+			if !ok {
+				t.Fatalf("%s %s: expected *RPCError, got %v", codec.ContentType(), c.method, err)
+			}
+
+			if e.Code != c.code || e.Msg != c.msg {
+				t.Fatalf("%s %s: expected code=%d msg=%q, got %v", codec.ContentType(), c.method, c.code, c.msg, e)
+			}
+		}
+	}
+}
+
+func TestRPCClientErrorDetails(t *testing.T) {
+	for _, format := range []ErrorFormat{SimpleRespErrorFormat, ProblemJSONErrorFormat, JSONRPC2ErrorFormat} {
+		mux := NewMux("", nil, InjectRequestID(), HandleError(WithHTTPStatus(), WithErrorFormat(format)))
+		mux.Handle("/Fail", func(rw http.ResponseWriter, req *http.Request) error {
+			e, err := NewRPCErrorWithCode(http.StatusTooManyRequests).
+				WithReason("QUOTA").
+				WithFieldViolation("name", "too long").
+				WithRetryAfter(1500 * time.Millisecond).
+				WithDetails(common.NewResult(1, "detail"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return e
+		})
+
+		stdmux := http.NewServeMux()
+		mux.register(stdmux)
+
+		srv := httptest.NewServer(stdmux)
+
+		err := NewRPCClient(srv.URL, nil).Call(context.Background(), "/Fail", common.EMPTY, &common.SimpleResp{})
+		srv.Close()
+
+		e, ok := err.(*RPCError)
+		if !ok {
+			t.Fatalf("expected *RPCError, got %v", err)
+		}
+
+		if e.Code != http.StatusTooManyRequests || e.Reason != "QUOTA" || e.RetryAfter != 1500*time.Millisecond || e.ReqID == "" {
+			t.Fatalf("unexpected error %#v", e)
+		}
+
+		if len(e.FieldViolations) != 1 || e.FieldViolations[0].Field != "name" {
+			t.Fatalf("unexpected field violations %v", e.FieldViolations)
+		}
+
+		res := &common.Result{}
+		if len(e.Details) != 1 || ptypes.UnmarshalAny(e.Details[0], res) != nil || res.Msg != "detail" {
+			t.Fatalf("unexpected details %v", e.Details)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package jsonrpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// WithTracer traces calls & the opening of streams of the client by client spans of t. The trace context
+// is sent to servers by the traceparent header, which is also sent without the option if ctx carries a span.
+// Spans cover retries, and interceptors appended after this option
+func WithTracer(t *Tracer) ClientOption {
+	return func(rc *RPCClient) {
+		rc.interceptors = append(rc.interceptors, func(ctx context.Context, method string, req, resp proto.Message, invoker Invoker, opts ...CallOption) error {
+			ctx, span := t.Start(ctx, method, SpanKindClient)
+			defer span.End()
+
+			err := invoker(ctx, method, req, resp, opts...)
+			recordClientResult(span, err)
+			return err
+		})
+
+		rc.streamInterceptors = append(rc.streamInterceptors, func(ctx context.Context, method string, req proto.Message, streamer Streamer, opts ...CallOption) (*StreamReader, error) {
+			ctx, span := t.Start(ctx, method, SpanKindClient)
+			defer span.End()
+
+			sr, err := streamer(ctx, method, req, opts...)
+			recordClientResult(span, err)
+			return sr, err
+		})
+	}
+}
+
+func recordClientResult(span *Span, err error) {
+	if !span.recording() {
+		return
+	}
+
+	span.SetAttribute("rpc.system", "jsonrpc")
+	if err == nil {
+		return
+	}
+
+	e := ToRPCError(err)
+	span.RecordError(err)
+	span.SetAttribute("rpc.code", e.Code)
+	span.SetStatus(SpanStatusError, e.Msg)
+}
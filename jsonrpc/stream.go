@@ -0,0 +1,470 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfs-force-community/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// ContentTypeNDJSON media type for newline-delimited json streams
+	ContentTypeNDJSON = "application/x-ndjson"
+
+	// ContentTypeEventStream media type for server-sent events streams
+	ContentTypeEventStream = "text/event-stream"
+
+	sseEventError = "error"
+	sseEventEnd   = "end"
+)
+
+var (
+	_ grpc.ServerStream = (*ServerStream)(nil)
+
+	ctxKeyStreamConn = NewCtxKey("_stream_conn")
+)
+
+// streamConn carries messages of a call over a transport supporting bidirectional streaming
+type streamConn interface {
+	// markStreaming tells the transport that the handler replies with a stream rather than a single response
+	markStreaming()
+
+	send(msg []byte) error
+
+	// recv returns io.EOF after the client closes its sending side
+	recv(ctx context.Context) ([]byte, error)
+}
+
+// streamFrame is the envelope of each line of a ndjson stream, which ends with either an error or an end frame,
+// so that readers tell a complete stream from a broken connection
+type streamFrame struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *common.Result  `json:"error,omitempty"`
+	End    bool            `json:"end,omitempty"`
+}
+
+// NewServerStream returns a *ServerStream writing into rw, using server-sent events if the request
+// accepts text/event-stream, and newline-delimited json otherwise
+func NewServerStream(rw http.ResponseWriter, req *http.Request) *ServerStream {
+	if conn, ok := Extract(req, ctxKeyStreamConn).(streamConn); ok {
+		conn.markStreaming()
+
+		return &ServerStream{
+			ctx:     req.Context(),
+			rw:      rw,
+			conn:    conn,
+			header:  metadata.MD{},
+			trailer: metadata.MD{},
+		}
+	}
+
+	contentType := ContentTypeNDJSON
+	for _, mediaType := range parseAccept(req.Header.Get(acceptHeader)) {
+		if mediaType == ContentTypeEventStream || mediaType == ContentTypeNDJSON {
+			contentType = mediaType
+			break
+		}
+	}
+
+	flusher, _ := rw.(http.Flusher)
+
+	return &ServerStream{
+		ctx:         req.Context(),
+		rw:          rw,
+		flusher:     flusher,
+		contentType: contentType,
+		header:      metadata.MD{},
+		trailer:     metadata.MD{},
+	}
+}
+
+// NewDuplexStream returns a *ServerStream able to receive messages from the client,
+// which is only available for requests dispatched by the websocket endpoint
+func NewDuplexStream(rw http.ResponseWriter, req *http.Request) (*ServerStream, error) {
+	if _, ok := Extract(req, ctxKeyStreamConn).(streamConn); !ok {
+		return nil, NewRPCErrorWithCode(http.StatusNotImplemented, "client streaming is only supported over websocket")
+	}
+
+	return NewServerStream(rw, req), nil
+}
+
+// ServerStream implements grpc.ServerStream for streaming methods served over http or websocket,
+// each message is encoded as json and flushed to the client immediately
+type ServerStream struct {
+	ctx         context.Context
+	rw          http.ResponseWriter
+	flusher     http.Flusher
+	contentType string
+	conn        streamConn
+
+	header     metadata.MD
+	trailer    metadata.MD
+	headerSent bool
+}
+
+// SetHeader merges md into the response headers, which are sent along with the first message
+func (ss *ServerStream) SetHeader(md metadata.MD) error {
+	if ss.headerSent {
+		return fmt.Errorf("stream header already sent")
+	}
+
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+// SendHeader sends the response headers
+func (ss *ServerStream) SendHeader(md metadata.MD) error {
+	if err := ss.SetHeader(md); err != nil {
+		return err
+	}
+
+	ss.sendHeader()
+	return nil
+}
+
+// SetTrailer merges md into the trailers written when the stream finishes
+func (ss *ServerStream) SetTrailer(md metadata.MD) {
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
+
+// Context returns the context of the request
+func (ss *ServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// SendMsg writes a proto.Message as a single event or line
+func (ss *ServerStream) SendMsg(m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unexpected stream message type %T", m)
+	}
+
+	if err := ss.ctx.Err(); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBufferString("")
+	if err := EncodeJSON(buf, msg); err != nil {
+		return err
+	}
+
+	if ss.conn != nil {
+		return ss.conn.send(buf.Bytes())
+	}
+
+	return ss.writeFrame("", buf.Bytes())
+}
+
+// RecvMsg reads the next message sent by the client, returns io.EOF after the client closes its sending side.
+// Over plain http it always returns io.EOF, the only request message has been decoded before the stream starts
+func (ss *ServerStream) RecvMsg(m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unexpected stream message type %T", m)
+	}
+
+	if ss.conn == nil {
+		return io.EOF
+	}
+
+	b, err := ss.conn.recv(ss.ctx)
+	if err != nil {
+		return err
+	}
+
+	return DecodeJSON(bytes.NewReader(b), msg)
+}
+
+// Finish terminates the stream with the error returned by the service method.
+// Errors occurred before any message is sent are returned as is, so that HandleError can respond normally,
+// others are written as the last frame of the stream, and an end frame is written if err is nil
+func (ss *ServerStream) Finish(err error) error {
+	// the transport owning conn reports the result by itself
+	if ss.conn != nil {
+		return err
+	}
+
+	if err != nil && !ss.headerSent {
+		return err
+	}
+
+	ss.sendHeader()
+
+	if err != nil {
+		e := ToRPCError(err)
+		res := common.NewResult(int32(e.Code), e.Msg)
+
+		frame, _ := json.Marshal(streamFrame{Error: res})
+		if ss.contentType == ContentTypeEventStream {
+			frame, _ = json.Marshal(res)
+		}
+
+		if werr := ss.writeRaw(sseEventError, frame); werr != nil {
+			RequestLoggerFromCtx(ss.ctx).Warnf("unable to write stream error, cause=%v, err=%v", werr, err)
+		}
+	} else {
+		frame, _ := json.Marshal(streamFrame{End: true})
+		if ss.contentType == ContentTypeEventStream {
+			frame = []byte("{}")
+		}
+
+		if werr := ss.writeRaw(sseEventEnd, frame); werr != nil {
+			RequestLoggerFromCtx(ss.ctx).Warnf("unable to write stream end, err=%v", werr)
+		}
+	}
+
+	for k, v := range ss.trailer {
+		for _, vv := range v {
+			ss.rw.Header().Add(http.TrailerPrefix+k, vv)
+		}
+	}
+
+	return nil
+}
+
+// resumeServerStream returns the *ServerStream writing the stream response of rw, whose header has been sent,
+// e.g. for HandlePanic to terminate it with an error frame
+func resumeServerStream(rw http.ResponseWriter, req *http.Request) *ServerStream {
+	mediaType, _, _ := mime.ParseMediaType(rw.Header().Get(contentTypeHeader))
+	flusher, _ := rw.(http.Flusher)
+
+	return &ServerStream{
+		ctx:         req.Context(),
+		rw:          rw,
+		flusher:     flusher,
+		contentType: mediaType,
+		header:      metadata.MD{},
+		trailer:     metadata.MD{},
+		headerSent:  true,
+	}
+}
+
+func (ss *ServerStream) sendHeader() {
+	if ss.headerSent {
+		return
+	}
+
+	ss.headerSent = true
+
+	if ss.conn != nil {
+		return
+	}
+
+	header := ss.rw.Header()
+	for k, v := range ss.header {
+		for _, vv := range v {
+			header.Add(k, vv)
+		}
+	}
+
+	header.Set(contentTypeHeader, ss.contentType)
+	header.Set("Cache-Control", "no-cache")
+	ss.rw.WriteHeader(http.StatusOK)
+}
+
+func (ss *ServerStream) writeFrame(event string, msg []byte) error {
+	ss.sendHeader()
+
+	if ss.contentType == ContentTypeNDJSON {
+		frame, err := json.Marshal(streamFrame{Result: msg})
+		if err != nil {
+			return err
+		}
+
+		msg = frame
+	}
+
+	return ss.writeRaw(event, msg)
+}
+
+func (ss *ServerStream) writeRaw(event string, frame []byte) error {
+	buf := bytes.NewBufferString("")
+
+	if ss.contentType == ContentTypeEventStream {
+		if event != "" {
+			fmt.Fprintf(buf, "event: %s\n", event)
+		}
+
+		fmt.Fprintf(buf, "data: %s\n\n", frame)
+	} else {
+		buf.Write(frame)
+		buf.WriteByte('\n')
+	}
+
+	if _, err := ss.rw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if ss.flusher != nil {
+		ss.flusher.Flush()
+	}
+
+	return nil
+}
+
+// StreamReader reads messages of a server-streaming method from the response body
+type StreamReader struct {
+	body        io.ReadCloser
+	r           *bufio.Reader
+	contentType string
+	reqID       string
+	grpcStatus  bool
+
+	// ended is set after the terminal frame is read
+	ended bool
+}
+
+func newStreamReader(resp *http.Response) (*StreamReader, error) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(contentTypeHeader))
+	if mediaType != ContentTypeNDJSON && mediaType != ContentTypeEventStream {
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read response body, err=%v", err)
+		}
+
+		codec, ok := LookupCodec(resp.Header.Get(contentTypeHeader))
+		if !ok {
+			codec = JSONCodec
+		}
+
+		return nil, errorFromResponse(resp, codec, body)
+	}
+
+	return &StreamReader{
+		body:        resp.Body,
+		r:           bufio.NewReader(resp.Body),
+		contentType: mediaType,
+		reqID:       resp.Header.Get(RequestIDHeader),
+	}, nil
+}
+
+// Recv reads the next message into recv, returns io.EOF when the stream ends normally,
+// an *RPCError if the server terminates the stream with an error,
+// and io.ErrUnexpectedEOF if the connection is closed before the stream ends, e.g. by a proxy timeout
+func (sr *StreamReader) Recv(recv proto.Message) error {
+	err := sr.recv(recv)
+	if e, ok := err.(*RPCError); ok && sr.grpcStatus {
+		return e.GRPCStatus().Err()
+	}
+
+	return err
+}
+
+func (sr *StreamReader) recv(recv proto.Message) error {
+	if sr.ended {
+		return io.EOF
+	}
+
+	if sr.contentType == ContentTypeEventStream {
+		return sr.recvEvent(recv)
+	}
+
+	for {
+		line, err := sr.r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+
+			continue
+		}
+
+		frame := streamFrame{}
+		if err := json.Unmarshal(line, &frame); err != nil {
+			return fmt.Errorf("unable to unmarshal stream frame, err=%v", err)
+		}
+
+		if frame.End {
+			sr.ended = true
+			return io.EOF
+		}
+
+		if frame.Error != nil {
+			sr.ended = true
+			return &RPCError{
+				Code:  int(frame.Error.Code),
+				Msg:   frame.Error.Msg,
+				ReqID: sr.reqID,
+			}
+		}
+
+		return DecodeJSON(bytes.NewReader(frame.Result), recv)
+	}
+}
+
+func (sr *StreamReader) recvEvent(recv proto.Message) error {
+	event := ""
+	data := bytes.NewBufferString("")
+
+	for {
+		line, err := sr.r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "" && data.Len() > 0:
+			if event == sseEventEnd {
+				sr.ended = true
+				return io.EOF
+			}
+
+			if event == sseEventError {
+				sr.ended = true
+
+				res := &common.Result{}
+				if err := DecodeJSON(data, res); err != nil {
+					return fmt.Errorf("unable to unmarshal stream error, err=%v", err)
+				}
+
+				return &RPCError{
+					Code:  int(res.Code),
+					Msg:   res.Msg,
+					ReqID: sr.reqID,
+				}
+			}
+
+			return DecodeJSON(data, recv)
+
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+// unexpectedEOF converts io.EOF read before the terminal frame of a stream
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// Close closes the underlying response body
+func (sr *StreamReader) Close() error {
+	return sr.body.Close()
+}
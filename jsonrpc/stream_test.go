@@ -0,0 +1,78 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestStreamTermination(t *testing.T) {
+	mux := NewMux("/v1", nil, HandleError(), HandlePanic())
+	mux.Handle("/Stream", func(rw http.ResponseWriter, req *http.Request) error {
+		stream := NewServerStream(rw, req)
+		for i := 0; i < 2; i++ {
+			if err := stream.SendMsg(&common.SimpleResp{Res: common.NewResult(int32(i), "")}); err != nil {
+				return stream.Finish(err)
+			}
+		}
+
+		if req.URL.Query().Get("panic") != "" {
+			panic("boom")
+		}
+
+		return stream.Finish(nil)
+	})
+
+	serve := func(accept, query string) (*httptest.ResponseRecorder, *http.Response) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/Stream"+query, nil)
+		req.Header.Set(acceptHeader, accept)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec, rec.Result()
+	}
+
+	recvAll := func(resp *http.Response) ([]int32, error) {
+		sr, err := newStreamReader(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var codes []int32
+		for {
+			msg := &common.SimpleResp{}
+			if err := sr.Recv(msg); err != nil {
+				return codes, err
+			}
+
+			codes = append(codes, msg.Res.Code)
+		}
+	}
+
+	for _, accept := range []string{ContentTypeNDJSON, ContentTypeEventStream} {
+		_, resp := serve(accept, "")
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if codes, err := recvAll(resp); err != io.EOF || len(codes) != 2 {
+			t.Fatalf("%s: expected complete stream, got %v, err=%v", accept, codes, err)
+		}
+
+		// a connection cut before the end frame is not a complete stream
+		end := bytes.LastIndex(bytes.TrimRight(body, "\n"), []byte("\n"))
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body[:end+1]))
+		if codes, err := recvAll(resp); err != io.ErrUnexpectedEOF || len(codes) != 2 {
+			t.Fatalf("%s: expected unexpected eof for truncated stream, got %v, err=%v", accept, codes, err)
+		}
+
+		_, resp = serve(accept, "?panic=1")
+		if codes, err := recvAll(resp); ToRPCError(err).Code != http.StatusInternalServerError || len(codes) != 2 {
+			t.Fatalf("%s: expected stream error after panic, got %v, err=%v", accept, codes, err)
+		}
+	}
+}
@@ -0,0 +1,610 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs-force-community/gosf/metric"
+	"github.com/ipfs-force-community/gosf/proc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	maxTracestateLen = 512
+)
+
+func init() {
+	metric.Collect(droppedSpansMetric)
+}
+
+var droppedSpansMetric = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "service",
+		Subsystem: proc.AppName(),
+		Name:      "trace_spans_dropped_total",
+	},
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// IsValid reports whether the id is non-zero
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// IsValid reports whether the id is non-zero
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span propagated across services, by the traceparent & tracestate headers
+// of the W3C trace context
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+
+	// Remote is true if the span context is received from another service
+	Remote bool
+}
+
+// IsValid reports whether both ids are non-zero
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// traceparent formats the span context as the traceparent header of version 00
+func (sc SpanContext) traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// parseTraceparent parses the traceparent header, invalid values are ignored as the spec requires
+func parseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+
+	// version-trace_id-span_id-flags, future versions may append more fields
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || !isLowerHex(s[:2]) || s[:2] == "ff" {
+		return sc, false
+	}
+
+	if (s[:2] == "00" && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return sc, false
+	}
+
+	if !isLowerHex(s[3:35]) || !isLowerHex(s[36:52]) || !isLowerHex(s[53:55]) {
+		return sc, false
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(s[3:35]))
+	hex.Decode(sc.SpanID[:], []byte(s[36:52]))
+
+	flags, _ := strconv.ParseUint(s[53:55], 16, 8)
+	sc.Sampled = flags&1 == 1
+	sc.Remote = true
+
+	return sc, sc.IsValid()
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// injectTraceContext sets the trace context headers of the span in ctx, unless already set
+func injectTraceContext(ctx context.Context, header http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() || header.Get(traceparentHeader) != "" {
+		return
+	}
+
+	header.Set(traceparentHeader, sc.traceparent())
+	if sc.TraceState != "" {
+		header.Set(tracestateHeader, sc.TraceState)
+	}
+}
+
+// extractTraceContext returns the span context sent by the caller
+func extractTraceContext(header http.Header) (SpanContext, bool) {
+	sc, ok := parseTraceparent(strings.TrimSpace(header.Get(traceparentHeader)))
+	if !ok {
+		return sc, false
+	}
+
+	if state := strings.Join(header[http.CanonicalHeaderKey(tracestateHeader)], ","); len(state) <= maxTracestateLen {
+		sc.TraceState = strings.TrimSpace(state)
+	}
+
+	return sc, true
+}
+
+// SpanKind is the role of a span, valued as in OTLP
+type SpanKind int
+
+// span kinds
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+
+	case SpanKindClient:
+		return "client"
+	}
+
+	return "internal"
+}
+
+// SpanStatus is the status of a span, valued as in OTLP
+type SpanStatus int
+
+// span statuses
+const (
+	SpanStatusUnset SpanStatus = 0
+	SpanStatusOK    SpanStatus = 1
+	SpanStatusError SpanStatus = 2
+)
+
+func (s SpanStatus) String() string {
+	switch s {
+	case SpanStatusOK:
+		return "ok"
+
+	case SpanStatusError:
+		return "error"
+	}
+
+	return "unset"
+}
+
+// SpanEvent is an event occurred during a span, e.g. an error
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// SpanData is a finished span passed to exporters
+type SpanData struct {
+	Service      string
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+
+	// Attributes are of string, bool, int64 or float64
+	Attributes map[string]interface{}
+	Events     []SpanEvent
+
+	Status        SpanStatus
+	StatusMessage string
+}
+
+// Span is a traced operation started by Tracer.Start. Methods of a nil *Span are no-op,
+// so that code instrumented by SpanFromContext works without a tracer
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the span context, which is zero for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.sc
+}
+
+// recording reports whether the span will be exported
+func (s *Span) recording() bool {
+	return s != nil && s.sc.Sampled
+}
+
+// SetAttribute sets an attribute of the span, ints are stored as int64 and other unsupported values as strings
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.data.Attributes[key] = attributeValue(value)
+}
+
+// SetStatus sets the status of the span, the message only matters for SpanStatusError
+func (s *Span) SetStatus(status SpanStatus, msg string) {
+	if !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.data.Status = status
+	if status == SpanStatusError {
+		s.data.StatusMessage = msg
+	}
+}
+
+// RecordError records err as an "exception" event, without changing the status
+func (s *Span) RecordError(err error) {
+	if err == nil || !s.recording() {
+		return
+	}
+
+	attrs := map[string]interface{}{
+		"exception.message": err.Error(),
+		"exception.type":    fmt.Sprintf("%T", err),
+	}
+
+	if e, ok := err.(*RPCError); ok {
+		attrs["rpc.code"] = int64(e.Code)
+		if e.Reason != "" {
+			attrs["rpc.error_reason"] = e.Reason
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.data.Events = append(s.data.Events, SpanEvent{
+		Name:       "exception",
+		Time:       time.Now(),
+		Attributes: attrs,
+	})
+}
+
+// End finishes the span and queues it for exporting, calls after the first one are ignored
+func (s *Span) End() {
+	if !s.recording() {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.data.End = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.enqueue(s.data)
+}
+
+func attributeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string, bool, int64, float64:
+		return val
+
+	case int:
+		return int64(val)
+
+	case int32:
+		return int64(val)
+
+	case uint32:
+		return int64(val)
+
+	case float32:
+		return float64(val)
+
+	case time.Duration:
+		return val.String()
+
+	case fmt.Stringer:
+		return val.String()
+	}
+
+	return fmt.Sprint(v)
+}
+
+var ctxKeySpan = NewCtxKey("_span")
+
+// SpanFromContext returns the current span in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(ctxKeySpan).(*Span)
+	return s
+}
+
+// ContextWithSpan returns a copy of ctx carrying the span, as the parent of spans started with it
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, ctxKeySpan, s)
+}
+
+// DefaultTracerConfig default tracer config
+var DefaultTracerConfig = TracerConfig{
+	SampleRatio:   1,
+	QueueSize:     2048,
+	BatchSize:     512,
+	FlushInterval: 5 * time.Second,
+	ExportTimeout: 10 * time.Second,
+}
+
+// TracerConfig configures NewTracer
+type TracerConfig struct {
+	// ServiceName is reported with all spans, defaults to proc.AppName()
+	ServiceName string
+
+	// Exporter exports finished spans in batches, e.g. NewOTLPSpanExporter or NewWriterSpanExporter
+	Exporter SpanExporter
+
+	// SampleRatio is the ratio of traces started by this service to be sampled, zero falls back to the default
+	// of sampling all. Spans with a parent follow the sampling decision of the parent
+	SampleRatio float64
+
+	// QueueSize bounds the number of spans waiting for exporting, spans are dropped once exceeded
+	QueueSize int
+
+	// BatchSize is the max number of spans of a batch
+	BatchSize int
+
+	// FlushInterval is the max time spans wait before exporting
+	FlushInterval time.Duration
+
+	// ExportTimeout bounds each call of the exporter
+	ExportTimeout time.Duration
+
+	// Logger logs export failures, defaults to zap.S()
+	Logger Logger
+}
+
+// Tracer starts spans and exports them in the background, until Shutdown
+type Tracer struct {
+	cfg TracerConfig
+
+	queue    chan SpanData
+	closed   int32
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTracer creates a tracer exporting spans by cfg.Exporter, Shutdown must be called to flush the remaining spans
+func NewTracer(cfg TracerConfig) *Tracer {
+	def := DefaultTracerConfig
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = proc.AppName()
+	}
+
+	if cfg.SampleRatio <= 0 {
+		cfg.SampleRatio = def.SampleRatio
+	}
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = def.QueueSize
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = def.FlushInterval
+	}
+
+	if cfg.ExportTimeout <= 0 {
+		cfg.ExportTimeout = def.ExportTimeout
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = stdLogger
+	}
+
+	t := &Tracer{
+		cfg:   cfg,
+		queue: make(chan SpanData, cfg.QueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go t.run()
+	return t
+}
+
+// Start starts a span as a child of the span in ctx, and returns a copy of ctx carrying the new span.
+// The span must be ended by Span.End
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := t.start(SpanFromContext(ctx).SpanContext(), name, kind)
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *Tracer) start(parent SpanContext, name string, kind SpanKind) *Span {
+	sc := SpanContext{
+		SpanID: newSpanID(),
+	}
+
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+
+	s := &Span{
+		tracer: t,
+		sc:     sc,
+	}
+
+	if sc.Sampled {
+		s.data = SpanData{
+			Service:      t.cfg.ServiceName,
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			Start:        time.Now(),
+			Attributes:   map[string]interface{}{},
+		}
+	}
+
+	return s
+}
+
+// sample decides by the lower 63 bits of the trace id, so that services with the same ratio agree
+func (t *Tracer) sample(id TraceID) bool {
+	if t.cfg.SampleRatio >= 1 {
+		return true
+	}
+
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(t.cfg.SampleRatio*(1<<63))
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+
+	return id
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	if atomic.LoadInt32(&t.closed) == 1 {
+		droppedSpansMetric.Inc()
+		return
+	}
+
+	select {
+	case t.queue <- data:
+
+	default:
+		droppedSpansMetric.Inc()
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.cfg.BatchSize)
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.cfg.BatchSize {
+				batch = t.export(batch)
+			}
+
+		case <-ticker.C:
+			batch = t.export(batch)
+
+		case <-t.stop:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+					if len(batch) >= t.cfg.BatchSize {
+						batch = t.export(batch)
+					}
+
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export exports the batch, and returns an empty batch for reuse
+func (t *Tracer) export(batch []SpanData) []SpanData {
+	if len(batch) == 0 || t.cfg.Exporter == nil {
+		return batch[:0]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.cfg.ExportTimeout)
+	defer cancel()
+
+	if err := t.cfg.Exporter.ExportSpans(ctx, batch); err != nil {
+		t.cfg.Logger.Warnf("unable to export spans, count=%d, err=%v", len(batch), err)
+	}
+
+	return make([]SpanData, 0, t.cfg.BatchSize)
+}
+
+// Shutdown exports the remaining spans and shuts the exporter down, spans ended afterwards are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() {
+		atomic.StoreInt32(&t.closed, 1)
+		close(t.stop)
+	})
+
+	select {
+	case <-t.done:
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if t.cfg.Exporter == nil {
+		return nil
+	}
+
+	return t.cfg.Exporter.Shutdown(ctx)
+}
@@ -0,0 +1,350 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanExporter exports finished spans, ExportSpans is called by a single goroutine of the tracer
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+
+	// Shutdown releases resources of the exporter, called once by Tracer.Shutdown
+	Shutdown(ctx context.Context) error
+}
+
+// NewWriterSpanExporter writes spans to w as json lines, e.g. os.Stdout for local debugging & tests
+func NewWriterSpanExporter(w io.Writer) SpanExporter {
+	return &writerSpanExporter{w: w}
+}
+
+// NewFileSpanExporter appends spans to the file at path as json lines, the file is closed by Shutdown
+func NewFileSpanExporter(path string) (SpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writerSpanExporter{w: f, closer: f}, nil
+}
+
+type writerSpanExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// spanRecord is a span written by writerSpanExporter
+type spanRecord struct {
+	Service       string                 `json:"service"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	TraceState    string                 `json:"trace_state,omitempty"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Duration      string                 `json:"duration"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []spanEventRecord      `json:"events,omitempty"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+type spanEventRecord struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func (e *writerSpanExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	buf := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(buf)
+	for _, s := range spans {
+		rec := spanRecord{
+			Service:       s.Service,
+			Name:          s.Name,
+			Kind:          s.Kind.String(),
+			TraceID:       s.SpanContext.TraceID.String(),
+			SpanID:        s.SpanContext.SpanID.String(),
+			TraceState:    s.SpanContext.TraceState,
+			Start:         s.Start,
+			End:           s.End,
+			Duration:      s.End.Sub(s.Start).String(),
+			Attributes:    s.Attributes,
+			Status:        s.Status.String(),
+			StatusMessage: s.StatusMessage,
+		}
+
+		if s.ParentSpanID.IsValid() {
+			rec.ParentSpanID = s.ParentSpanID.String()
+		}
+
+		for _, ev := range s.Events {
+			rec.Events = append(rec.Events, spanEventRecord{
+				Name:       ev.Name,
+				Time:       ev.Time,
+				Attributes: ev.Attributes,
+			})
+		}
+
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+func (e *writerSpanExporter) Shutdown(ctx context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+
+	return e.closer.Close()
+}
+
+const (
+	otlpTracesPath    = "/v1/traces"
+	otlpScopeName     = "github.com/ipfs-force-community/gosf/jsonrpc"
+	maxOTLPErrBodyLen = 1 << 10
+)
+
+// OTLPConfig configures NewOTLPSpanExporter
+type OTLPConfig struct {
+	// Endpoint is the url of the collector, e.g. "http://localhost:4318", "/v1/traces" is appended if it has no path
+	Endpoint string
+
+	// Headers are sent with each export, e.g. for authentication
+	Headers map[string]string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// NewOTLPSpanExporter exports spans to an OpenTelemetry collector by OTLP/HTTP, encoded as json
+func NewOTLPSpanExporter(cfg OTLPConfig) (SpanExporter, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q", cfg.Endpoint)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	return &otlpSpanExporter{
+		endpoint: u.String(),
+		cfg:      cfg,
+	}, nil
+}
+
+type otlpSpanExporter struct {
+	endpoint string
+	cfg      OTLPConfig
+}
+
+// otlp messages in the json mapping of protobuf, where 64-bit integers are encoded as strings
+type (
+	otlpTracesRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func (e *otlpSpanExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(newOTLPTracesRequest(spans))
+	if err != nil {
+		return fmt.Errorf("unable to marshal spans, err=%v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to build http request, err=%v", err)
+	}
+
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	req.Header.Set(contentTypeHeader, ContentTypeJSON)
+
+	resp, err := e.cfg.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxOTLPErrBodyLen))
+		return fmt.Errorf("otlp collector replied %d, body=%s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (e *otlpSpanExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// newOTLPTracesRequest groups spans by service, keeping the order of their first appearance
+func newOTLPTracesRequest(spans []SpanData) *otlpTracesRequest {
+	req := &otlpTracesRequest{}
+	index := map[string]int{}
+
+	for _, s := range spans {
+		i, ok := index[s.Service]
+		if !ok {
+			i = len(req.ResourceSpans)
+			index[s.Service] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{
+					Attributes: otlpAttributes(map[string]interface{}{"service.name": s.Service}),
+				},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}}},
+			})
+		}
+
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status: otlpStatus{
+				Code:    int(s.Status),
+				Message: s.StatusMessage,
+			},
+		}
+
+		if s.ParentSpanID.IsValid() {
+			span.ParentSpanID = s.ParentSpanID.String()
+		}
+
+		for _, ev := range s.Events {
+			span.Events = append(span.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+				Name:         ev.Name,
+				Attributes:   otlpAttributes(ev.Attributes),
+			})
+		}
+
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	return req
+}
+
+// otlpAttributes converts attributes sorted by keys
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		var v otlpAnyValue
+		switch val := attrs[k].(type) {
+		case bool:
+			v.BoolValue = &val
+
+		case int64:
+			s := strconv.FormatInt(val, 10)
+			v.IntValue = &s
+
+		case float64:
+			v.DoubleValue = &val
+
+		default:
+			s := fmt.Sprint(val)
+			v.StringValue = &s
+		}
+
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: v})
+	}
+
+	return kvs
+}
@@ -0,0 +1,196 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs-force-community/common"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, c := range []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	} {
+		sc, ok := parseTraceparent(c.header)
+		if ok != c.valid || (ok && sc.Sampled != c.sampled) {
+			t.Fatalf("%q: expected valid=%v sampled=%v, got %v %+v", c.header, c.valid, c.sampled, ok, sc)
+		}
+
+		if ok && c.header[:2] == "00" && sc.traceparent() != c.header {
+			t.Fatalf("%q: formatted as %q", c.header, sc.traceparent())
+		}
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := NewTracer(TracerConfig{ServiceName: "demo", Exporter: NewWriterSpanExporter(buf)})
+
+	var downstreamParent string
+	downstream := NewMux("/v1", nil, HandleError(), HandleTracing(tracer))
+	downstream.Handle("/Fail", func(rw http.ResponseWriter, req *http.Request) error {
+		downstreamParent = req.Header.Get(traceparentHeader)
+		return NewRPCErrorWithCode(http.StatusServiceUnavailable, "unavailable")
+	})
+
+	cli := NewInProcessRPCClient(downstream, WithTracer(tracer))
+
+	upstream := NewMux("/v1", nil, HandleError(), HandleTracing(tracer))
+	upstream.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		SpanFromContext(req.Context()).SetAttribute("custom", 1)
+		if err := cli.Call(req.Context(), "/v1/Fail", common.EMPTY, &common.SimpleResp{}); err != nil {
+			return NewRPCErrorWithCode(http.StatusNotFound, "not found")
+		}
+
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	const inbound = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/v1/Call", nil)
+	req.Header.Set(traceparentHeader, inbound)
+	req.Header.Set(tracestateHeader, "vendor=x")
+	upstream.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]spanRecord{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		rec := spanRecord{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+
+		spans[rec.Kind+" "+rec.Name] = rec
+	}
+
+	server, client, down := spans["server /v1/Call"], spans["client /v1/Fail"], spans["server /v1/Fail"]
+	if len(spans) != 3 || server.SpanID == "" || client.SpanID == "" || down.SpanID == "" {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+
+	for _, s := range spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.TraceState != "vendor=x" || s.Service != "demo" {
+			t.Fatalf("unexpected trace of span %+v", s)
+		}
+	}
+
+	if server.ParentSpanID != "00f067aa0ba902b7" || client.ParentSpanID != server.SpanID || down.ParentSpanID != client.SpanID {
+		t.Fatalf("unexpected parents, server=%+v, client=%+v, downstream=%+v", server, client, down)
+	}
+
+	if downstreamParent != "00-"+client.TraceID+"-"+client.SpanID+"-01" {
+		t.Fatalf("unexpected traceparent sent downstream %q", downstreamParent)
+	}
+
+	// 4xx are not errors of the server, while any failed call is an error of the client
+	if server.Status != "unset" || server.Attributes["rpc.code"] != float64(http.StatusNotFound) || server.Attributes["custom"] != float64(1) {
+		t.Fatalf("unexpected server span %+v", server)
+	}
+
+	if client.Status != "error" || down.Status != "error" || down.StatusMessage != "unavailable" || len(down.Events) != 1 {
+		t.Fatalf("unexpected error spans, client=%+v, downstream=%+v", client, down)
+	}
+}
+
+func TestTracingNotSampled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := NewTracer(TracerConfig{Exporter: NewWriterSpanExporter(buf)})
+
+	var downstreamParent string
+	downstream := NewMux("/v1", nil, HandleTracing(tracer))
+	downstream.Handle("/Get", func(rw http.ResponseWriter, req *http.Request) error {
+		downstreamParent = req.Header.Get(traceparentHeader)
+		return EncodeResponseFor(rw, req, common.EMPTY)
+	})
+
+	cli := NewInProcessRPCClient(downstream)
+
+	upstream := NewMux("/v1", nil, HandleTracing(tracer))
+	upstream.Handle("/Call", func(rw http.ResponseWriter, req *http.Request) error {
+		return cli.Call(req.Context(), "/v1/Get", common.EMPTY, &common.SimpleResp{})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/Call", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	upstream.ServeHTTP(httptest.NewRecorder(), req)
+
+	tracer.Shutdown(context.Background())
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected no span exported, got %s", buf.String())
+	}
+
+	sc, ok := parseTraceparent(downstreamParent)
+	if !ok || sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace context propagated without sampling, got %q", downstreamParent)
+	}
+}
+
+func TestOTLPSpanExporter(t *testing.T) {
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != otlpTracesPath || req.Header.Get("X-Token") != "secret" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(b, &body)
+	}))
+	defer srv.Close()
+
+	exporter, err := NewOTLPSpanExporter(OTLPConfig{Endpoint: srv.URL, Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := NewTracer(TracerConfig{ServiceName: "demo", Exporter: exporter})
+	_, span := tracer.Start(context.Background(), "op", SpanKindInternal)
+	span.SetAttribute("n", 42)
+	span.SetStatus(SpanStatusError, "boom")
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "demo" {
+		t.Fatalf("unexpected resource %v", rs["resource"])
+	}
+
+	s := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if s["name"] != "op" || s["traceId"] != span.SpanContext().TraceID.String() || s["kind"] != float64(SpanKindInternal) {
+		t.Fatalf("unexpected span %v", s)
+	}
+
+	attr := s["attributes"].([]interface{})[0].(map[string]interface{})
+	if attr["key"] != "n" || attr["value"].(map[string]interface{})["intValue"] != "42" {
+		t.Fatalf("unexpected attributes %v", s["attributes"])
+	}
+
+	if status := s["status"].(map[string]interface{}); status["code"] != float64(SpanStatusError) || status["message"] != "boom" {
+		t.Fatalf("unexpected status %v", status)
+	}
+}
@@ -0,0 +1,471 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ipfs-force-community/common"
+)
+
+// frame types sent by websocket clients
+const (
+	WSFrameCall      = "call"
+	WSFrameSend      = "send"
+	WSFrameCloseSend = "close_send"
+	WSFrameCancel    = "cancel"
+)
+
+// frame types sent by the websocket endpoint
+const (
+	WSFrameResult  = "result"
+	WSFrameMessage = "message"
+	WSFrameEnd     = "end"
+	WSFrameError   = "error"
+)
+
+var ctxKeyWebSocket = NewCtxKey("_websocket")
+
+// DefaultWebSocketConfig default websocket endpoint config
+var DefaultWebSocketConfig = WebSocketConfig{
+	ReadLimit:     4 << 20,
+	PingInterval:  30 * time.Second,
+	WriteTimeout:  10 * time.Second,
+	StreamBacklog: 64,
+}
+
+// WebSocketConfig websocket endpoint config
+type WebSocketConfig struct {
+	// CheckOrigin validates the Origin header of the upgrade request, same origin is required if nil
+	CheckOrigin func(req *http.Request) bool
+
+	// ReadLimit max size in bytes of a single frame sent by the client
+	ReadLimit int64
+
+	// PingInterval interval of keepalive pings, the connection is closed if no pong received in two intervals
+	PingInterval time.Duration
+
+	// WriteTimeout deadline for writing a single frame
+	WriteTimeout time.Duration
+
+	// StreamBacklog max count of client messages queued for a streaming call,
+	// the call is canceled once exceeded
+	StreamBacklog int
+}
+
+// WSFrame is the json message exchanged over the websocket connection,
+// frames of the same call share the id chosen by the client in the "call" frame
+type WSFrame struct {
+	ID     json.RawMessage   `json:"id"`
+	Type   string            `json:"type"`
+	Method string            `json:"method,omitempty"`
+	Header map[string]string `json:"header,omitempty"`
+	Params json.RawMessage   `json:"params,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  *common.Result    `json:"error,omitempty"`
+}
+
+// IsWebSocketCall reports whether the request is dispatched by the websocket endpoint
+func IsWebSocketCall(req *http.Request) bool {
+	is, _ := Extract(req, ctxKeyWebSocket).(bool)
+	return is
+}
+
+// RegisterWebSocket registers a websocket endpoint for all handlers of jmux onto the given std *http.ServeMux,
+// and uses http.DefaultServeMux if stdmux is nil
+func RegisterWebSocket(stdmux *http.ServeMux, pattern string, jmux *Mux, cfg WebSocketConfig, mds ...Middleware) {
+	if stdmux == nil {
+		stdmux = http.DefaultServeMux
+	}
+
+	stdmux.Handle(pattern, jmux.WebSocketHandler(cfg, mds...))
+}
+
+// WebSocketHandler returns an endpoint multiplexing calls to any handler of the mux over one websocket connection.
+//
+// A client starts a call with {"id": 1, "type": "call", "method": "/v1/Svc/Method", "params": {...}},
+// "header" of the frame is merged into the headers of the upgrade request, e.g. to carry an Authorization token.
+// Unary methods reply a "result" frame, streaming methods reply "message" frames followed by an "end" frame,
+// and any failure is replied as an "error" frame. Clients of client-streaming & bidi-streaming methods push
+// messages with "send" frames and half-close with a "close_send" frame, a "cancel" frame aborts a call,
+// e.g. to unsubscribe from a server-streaming method.
+//
+// Each call runs through the whole middleware chain of its handler, while the given mds only wrap the upgrade request.
+func (m *Mux) WebSocketHandler(cfg WebSocketConfig, mds ...Middleware) http.Handler {
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = DefaultWebSocketConfig.ReadLimit
+	}
+
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = DefaultWebSocketConfig.PingInterval
+	}
+
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultWebSocketConfig.WriteTimeout
+	}
+
+	if cfg.StreamBacklog <= 0 {
+		cfg.StreamBacklog = DefaultWebSocketConfig.StreamBacklog
+	}
+
+	h := &wsHandler{
+		cfg: cfg,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: cfg.CheckOrigin,
+		},
+		mux: m,
+	}
+
+	var hdl HandlerFunc = h.serve
+	for size := len(mds); size > 0; size-- {
+		hdl = mds[size-1](hdl)
+	}
+
+	return route{
+		pattern: "",
+		handler: hdl,
+		logger:  m.logger,
+	}.httpHandler()
+}
+
+type wsHandler struct {
+	cfg      WebSocketConfig
+	upgrader websocket.Upgrader
+	mux      *Mux
+}
+
+func (h *wsHandler) serve(rw http.ResponseWriter, req *http.Request) error {
+	conn, err := h.upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		// the upgrader has already replied with an error status
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+
+	wc := &wsConn{
+		handler: h,
+		conn:    conn,
+		outer:   req,
+		ctx:     ctx,
+		calls:   map[string]*wsCall{},
+	}
+
+	wc.run()
+	cancel()
+
+	return nil
+}
+
+type wsConn struct {
+	handler *wsHandler
+	conn    *websocket.Conn
+	outer   *http.Request
+	ctx     context.Context
+
+	writeMu sync.Mutex
+
+	callsMu sync.Mutex
+	calls   map[string]*wsCall
+	wg      sync.WaitGroup
+}
+
+func (wc *wsConn) run() {
+	logger := RequestLogger(wc.outer)
+	cfg := wc.handler.cfg
+
+	wc.conn.SetReadLimit(cfg.ReadLimit)
+	wc.conn.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+	wc.conn.SetPongHandler(func(string) error {
+		return wc.conn.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+	})
+
+	done := make(chan struct{})
+	go wc.keepalive(done)
+
+	for {
+		frame := &WSFrame{}
+		if err := wc.conn.ReadJSON(frame); err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				wc.replyError(nil, NewRPCErrorWithCode(http.StatusBadRequest, err.Error()))
+				continue
+			}
+
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Debugf("websocket connection closed, remote=%s, cause=%v", wc.outer.RemoteAddr, err)
+			}
+
+			break
+		}
+
+		wc.handle(frame)
+	}
+
+	close(done)
+
+	wc.callsMu.Lock()
+	for _, call := range wc.calls {
+		call.cancel()
+	}
+	wc.callsMu.Unlock()
+
+	wc.wg.Wait()
+	wc.conn.Close()
+}
+
+func (wc *wsConn) keepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(wc.handler.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+			wc.writeMu.Lock()
+			err := wc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wc.handler.cfg.WriteTimeout))
+			wc.writeMu.Unlock()
+
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (wc *wsConn) reply(frame *WSFrame) error {
+	wc.writeMu.Lock()
+	defer wc.writeMu.Unlock()
+
+	wc.conn.SetWriteDeadline(time.Now().Add(wc.handler.cfg.WriteTimeout))
+	return wc.conn.WriteJSON(frame)
+}
+
+func (wc *wsConn) replyError(id json.RawMessage, err error) {
+	e := ToRPCError(err)
+	res := common.NewResult(int32(e.Code), e.Msg)
+
+	wc.reply(&WSFrame{ID: id, Type: WSFrameError, Error: res})
+}
+
+func (wc *wsConn) handle(frame *WSFrame) {
+	key := string(bytes.TrimSpace(frame.ID))
+
+	if frame.Type == WSFrameCall {
+		wc.start(key, frame)
+		return
+	}
+
+	wc.callsMu.Lock()
+	call := wc.calls[key]
+	wc.callsMu.Unlock()
+
+	if call == nil {
+		wc.replyError(frame.ID, NewRPCErrorWithCode(http.StatusNotFound, fmt.Sprintf("no active call, id=%s", key)))
+		return
+	}
+
+	switch frame.Type {
+	case WSFrameSend:
+		call.push(frame.Params)
+
+	case WSFrameCloseSend:
+		call.closeSend()
+
+	case WSFrameCancel:
+		call.cancel()
+
+	default:
+		wc.replyError(frame.ID, NewRPCErrorWithCode(http.StatusBadRequest, fmt.Sprintf("unknown frame type %q", frame.Type)))
+	}
+}
+
+func (wc *wsConn) start(key string, frame *WSFrame) {
+	if key == "" || key == "null" {
+		wc.replyError(frame.ID, NewRPCErrorWithCode(http.StatusBadRequest, "call id required"))
+		return
+	}
+
+	pattern := frame.Method
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+
+	rt, pathParams, err := wc.handler.mux.router().match(http.MethodPost, pattern)
+	if err != nil {
+		wc.replyError(frame.ID, NewRPCErrorWithCode(http.StatusNotFound, "method not found: "+frame.Method))
+		return
+	}
+
+	params, ok := jsonrpc2Params(frame.Params)
+	if !ok {
+		wc.replyError(frame.ID, NewRPCErrorWithCode(http.StatusBadRequest, "params should be an object"))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(wc.ctx)
+	call := &wsCall{
+		conn:    wc,
+		id:      frame.ID,
+		cancel:  cancel,
+		inbound: make(chan []byte, wc.handler.cfg.StreamBacklog),
+	}
+
+	wc.callsMu.Lock()
+	if _, dup := wc.calls[key]; dup {
+		wc.callsMu.Unlock()
+		cancel()
+		wc.replyError(frame.ID, NewRPCErrorWithCode(http.StatusConflict, fmt.Sprintf("duplicate call id=%s", key)))
+		return
+	}
+
+	wc.calls[key] = call
+	wc.callsMu.Unlock()
+
+	req, err := newDispatchedRequest(ctx, wc.outer, pattern, params)
+	if err != nil {
+		wc.finish(key, call)
+		wc.replyError(frame.ID, err)
+		return
+	}
+
+	req = Inject(req, ctxKeyWebSocket, true)
+	if pathParams != nil {
+		req = Inject(req, ctxKeyPathParams, pathParams)
+	}
+	req = Inject(req, ctxKeyStreamConn, call)
+	for k, v := range frame.Header {
+		req.Header.Set(k, v)
+	}
+
+	wc.wg.Add(1)
+	go func() {
+		defer wc.wg.Done()
+		defer wc.finish(key, call)
+
+		buf := newResponseBuffer()
+		err := rt.handler(buf, req)
+
+		if err == nil && buf.status() >= http.StatusBadRequest {
+			err = NewRPCErrorWithCode(buf.status())
+		}
+
+		switch {
+		case err != nil:
+			wc.replyError(call.id, err)
+
+		case call.isStreaming():
+			wc.reply(&WSFrame{ID: call.id, Type: WSFrameEnd})
+
+		default:
+			result := json.RawMessage(bytes.TrimSpace(buf.body.Bytes()))
+			if len(result) == 0 {
+				result = json.RawMessage("null")
+			}
+
+			wc.reply(&WSFrame{ID: call.id, Type: WSFrameResult, Result: result})
+		}
+	}()
+}
+
+func (wc *wsConn) finish(key string, call *wsCall) {
+	call.cancel()
+
+	wc.callsMu.Lock()
+	if wc.calls[key] == call {
+		delete(wc.calls, key)
+	}
+	wc.callsMu.Unlock()
+}
+
+var _ streamConn = (*wsCall)(nil)
+
+// wsCall is a call in progress over a websocket connection
+type wsCall struct {
+	conn   *wsConn
+	id     json.RawMessage
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	streaming bool
+	sendDone  bool
+	inbound   chan []byte
+}
+
+func (c *wsCall) markStreaming() {
+	c.mu.Lock()
+	c.streaming = true
+	c.mu.Unlock()
+}
+
+func (c *wsCall) isStreaming() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.streaming
+}
+
+func (c *wsCall) send(msg []byte) error {
+	return c.conn.reply(&WSFrame{ID: c.id, Type: WSFrameMessage, Result: msg})
+}
+
+func (c *wsCall) recv(ctx context.Context) ([]byte, error) {
+	// a canceled call must not look like a half-closed one, even with messages still buffered
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case msg, ok := <-c.inbound:
+		if !ok {
+			return nil, io.EOF
+		}
+
+		return msg, nil
+	}
+}
+
+func (c *wsCall) push(msg json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sendDone {
+		return
+	}
+
+	if len(msg) == 0 {
+		msg = json.RawMessage("{}")
+	}
+
+	select {
+	case c.inbound <- msg:
+
+	default:
+		// the handler does not keep up with the client, abort instead of blocking other calls of the connection.
+		// inbound is left open, so that the handler sees the cancellation rather than the end of the input
+		c.sendDone = true
+		c.cancel()
+	}
+}
+
+func (c *wsCall) closeSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.sendDone {
+		c.sendDone = true
+		close(c.inbound)
+	}
+}
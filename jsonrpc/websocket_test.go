@@ -0,0 +1,25 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestWSCallBacklogOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	call := &wsCall{
+		cancel:  cancel,
+		inbound: make(chan []byte, 1),
+	}
+
+	call.push(json.RawMessage(`{"n":1}`))
+	call.push(json.RawMessage(`{"n":2}`))
+
+	// the overflow cancels the call, which must never be mistaken for the end of the input
+	for i := 0; i < 100; i++ {
+		if _, err := call.recv(ctx); err != context.Canceled {
+			t.Fatalf("expected the call canceled, got %v", err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package metric
 
 import (
 	"context"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -49,7 +50,8 @@ func Collect(c ...prometheus.Collector) {
 	collectors = append(collectors, c...)
 }
 
-// Run start a pusher
+// Run start a pusher, which blocks until ctx is done, and pushes once more before returning,
+// so that metrics collected during shutdown are not lost
 func Run(ctx context.Context, cfg PushConfig) {
 	pusherOnce.Do(func() {
 		run(ctx, cfg)
@@ -76,7 +78,9 @@ func run(ctx context.Context, cfg PushConfig) {
 		cfg.Interval = DefaultConfig.Interval
 	}
 
-	pusher := push.New(gateway, "backend").Grouping("host", proc.Hostname())
+	pusher := push.New(gateway, "backend").
+		Grouping("host", proc.Hostname()).
+		Client(&http.Client{Timeout: cfg.Timeout})
 	for i := range collectors {
 		pusher = pusher.Collector(collectors[i])
 	}
@@ -86,6 +90,11 @@ func run(ctx context.Context, cfg PushConfig) {
 	for {
 		select {
 		case <-ctx.Done():
+			pushTicker.Stop()
+			if err := pusher.Push(); err != nil {
+				logger.LS().Debugf("unable to flush to prometheus gateway, err=%v", err)
+			}
+
 			return
 
 		case <-pushTicker.C:
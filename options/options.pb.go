@@ -0,0 +1,108 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: options/options.proto
+
+package options
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// CacheControl configures caching responses of a method by jsonrpc.HandleCache,
+// only methods requiring READ perm or no perm at all are cached
+type CacheControl struct {
+	// max_age is the time to live of cached responses in seconds
+	MaxAge uint32 `protobuf:"varint,1,opt,name=max_age,json=maxAge,proto3" json:"max_age,omitempty"`
+	// no_store disables caching, e.g. for methods matched by a default max age
+	NoStore              bool     `protobuf:"varint,2,opt,name=no_store,json=noStore,proto3" json:"no_store,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CacheControl) Reset()         { *m = CacheControl{} }
+func (m *CacheControl) String() string { return proto.CompactTextString(m) }
+func (*CacheControl) ProtoMessage()    {}
+func (*CacheControl) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fa3ac5190829870e, []int{0}
+}
+
+func (m *CacheControl) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CacheControl.Unmarshal(m, b)
+}
+func (m *CacheControl) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CacheControl.Marshal(b, m, deterministic)
+}
+func (m *CacheControl) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CacheControl.Merge(m, src)
+}
+func (m *CacheControl) XXX_Size() int {
+	return xxx_messageInfo_CacheControl.Size(m)
+}
+func (m *CacheControl) XXX_DiscardUnknown() {
+	xxx_messageInfo_CacheControl.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CacheControl proto.InternalMessageInfo
+
+func (m *CacheControl) GetMaxAge() uint32 {
+	if m != nil {
+		return m.MaxAge
+	}
+	return 0
+}
+
+func (m *CacheControl) GetNoStore() bool {
+	if m != nil {
+		return m.NoStore
+	}
+	return false
+}
+
+var E_CacheControl = &proto.ExtensionDesc{
+	ExtendedType:  (*descriptor.MethodOptions)(nil),
+	ExtensionType: (*CacheControl)(nil),
+	Field:         53001,
+	Name:          "gosf.cache_control",
+	Tag:           "bytes,53001,opt,name=cache_control",
+	Filename:      "options/options.proto",
+}
+
+func init() {
+	proto.RegisterType((*CacheControl)(nil), "gosf.CacheControl")
+	proto.RegisterExtension(E_CacheControl)
+}
+
+func init() { proto.RegisterFile("options/options.proto", fileDescriptor_fa3ac5190829870e) }
+
+var fileDescriptor_fa3ac5190829870e = []byte{
+	// 230 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x8f, 0xc1, 0x4a, 0xc3, 0x40,
+	0x10, 0x86, 0x89, 0x4a, 0x5b, 0xd6, 0xf6, 0x12, 0x10, 0xa3, 0x07, 0x09, 0x9e, 0x72, 0xb0, 0xbb,
+	0xa0, 0x37, 0x6f, 0xb6, 0x67, 0x11, 0xe2, 0x49, 0x2f, 0x21, 0xd9, 0x6e, 0x36, 0x0b, 0xdd, 0xfd,
+	0xc3, 0xee, 0x04, 0xea, 0x23, 0xf8, 0x12, 0x3e, 0xab, 0x6c, 0xd3, 0x42, 0x4e, 0xc3, 0x3f, 0x0c,
+	0xff, 0xf7, 0x0d, 0xbb, 0x41, 0x4f, 0x06, 0x2e, 0x88, 0xd3, 0xe4, 0xbd, 0x07, 0x21, 0xbd, 0xd2,
+	0x08, 0xed, 0x7d, 0xae, 0x01, 0xbd, 0x57, 0xe2, 0xb8, 0x6b, 0x86, 0x56, 0xec, 0x54, 0x90, 0xde,
+	0xf4, 0x04, 0x3f, 0xde, 0x3d, 0x6e, 0xd8, 0x72, 0x5b, 0xcb, 0x4e, 0x6d, 0xe1, 0xc8, 0x63, 0x9f,
+	0xde, 0xb2, 0xb9, 0xad, 0x0f, 0x55, 0xad, 0x55, 0x96, 0xe4, 0x49, 0xb1, 0x2a, 0x67, 0xb6, 0x3e,
+	0xbc, 0x69, 0x95, 0xde, 0xb1, 0x85, 0x43, 0x15, 0x08, 0x5e, 0x65, 0x17, 0x79, 0x52, 0x2c, 0xca,
+	0xb9, 0xc3, 0x67, 0x8c, 0xaf, 0x5f, 0x6c, 0x25, 0x63, 0x47, 0x25, 0x4f, 0x25, 0x0f, 0x7c, 0xe4,
+	0xf2, 0x33, 0x97, 0xbf, 0x2b, 0xea, 0xb0, 0xfb, 0x18, 0x15, 0xb3, 0xdf, 0xbf, 0xcb, 0x3c, 0x29,
+	0xae, 0x9f, 0x53, 0x1e, 0x2d, 0xf9, 0x54, 0xa0, 0x5c, 0xca, 0x49, 0xda, 0xf0, 0xef, 0x27, 0x6d,
+	0xa8, 0x1b, 0x1a, 0x2e, 0x61, 0x85, 0xe9, 0xdb, 0xb0, 0x6e, 0xe1, 0xa5, 0x5a, 0x4b, 0x58, 0x3b,
+	0x38, 0x43, 0x3f, 0x22, 0x56, 0x9c, 0x9f, 0x6f, 0x66, 0x47, 0xe2, 0xcb, 0xff, 0x00, 0x3c, 0x1d,
+	0xa8, 0x53, 0x16, 0x01, 0x00, 0x00,
+}
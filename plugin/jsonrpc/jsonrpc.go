@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/ipfs-force-community/common"
+	"github.com/ipfs-force-community/gosf/options"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -17,7 +18,9 @@ func init() {
 }
 
 const (
-	httpPkgPath = "net/http"
+	contextPkgPath = "context"
+	httpPkgPath    = "net/http"
+	timePkgPath    = "time"
 
 	jsonrpcPkgPath     = "github.com/ipfs-force-community/gosf/jsonrpc"
 	accessPkgPath      = "github.com/ipfs-force-community/gosf/jsonrpc/access"
@@ -37,7 +40,9 @@ func New() *Plugin {
 type Plugin struct {
 	*generator.Generator
 
+	contextPkg     string
 	httpPkg        string
+	timePkg        string
 	jsonrpcPkg     string
 	accessPkg      string
 	protoCommonPkg string
@@ -59,13 +64,17 @@ func (p *Plugin) Generate(fd *generator.FileDescriptor) {
 		return
 	}
 
+	p.contextPkg = string(p.AddImport(contextPkgPath))
 	p.httpPkg = string(p.AddImport(httpPkgPath))
+	p.timePkg = string(p.AddImport(timePkgPath))
 	p.jsonrpcPkg = string(p.AddImport(jsonrpcPkgPath))
 	p.accessPkg = string(p.AddImport(accessPkgPath))
 	p.protoCommonPkg = string(p.AddImport(protoCommonPkgPath))
 
 	p.P("// Reference imports for jsonrpc")
+	p.P("var _ ", p.contextPkg, ".Context")
 	p.P("var _ ", p.httpPkg, ".ResponseWriter")
+	p.P("var _ ", p.timePkg, ".Duration")
 	p.P("var _ ", p.jsonrpcPkg, ".Logger")
 	p.P("var _ ", p.accessPkg, ".Fetcher")
 	p.P("var _ ", p.protoCommonPkg, ".Empty")
@@ -118,7 +127,27 @@ func (p *Plugin) generateService(pkgName string, sd *descriptor.ServiceDescripto
 
 	for _, md := range sd.GetMethod() {
 		methodName := generator.CamelCase(md.GetName())
-		p.P(fmt.Sprintf("mux.Handle(\"/%s\", %s(srv))", methodName, jsonrpcMethodHandlerName(srvName, methodName)))
+		grantScope, grantPerm := methodGrant(md)
+
+		var routeOpts string
+		if grantScope != "" {
+			routeOpts += fmt.Sprintf(", %s.WithAccess(%q, %s.Perm_%s)", p.jsonrpcPkg, grantScope, p.protoCommonPkg, grantPerm)
+		}
+
+		if cc := methodCacheControl(md); cc != nil {
+			var fields []string
+			if cc.MaxAge > 0 {
+				fields = append(fields, fmt.Sprintf("MaxAge: %d * %s.Second", cc.MaxAge, p.timePkg))
+			}
+
+			if cc.NoStore {
+				fields = append(fields, "NoStore: true")
+			}
+
+			routeOpts += fmt.Sprintf(", %s.WithCacheControl(%s.CacheControl{%s})", p.jsonrpcPkg, p.jsonrpcPkg, strings.Join(fields, ", "))
+		}
+
+		p.P(fmt.Sprintf("mux.Handle(\"/%s\", %s(srv)%s)", methodName, jsonrpcMethodHandlerName(srvName, methodName), routeOpts))
 	}
 
 	p.P()
@@ -129,12 +158,133 @@ func (p *Plugin) generateService(pkgName string, sd *descriptor.ServiceDescripto
 	for _, md := range sd.GetMethod() {
 		p.generateServiceMethod(pkgName, srvName, md)
 	}
+
+	p.generateClient(pkgName, srvName, sd)
 }
 
-func (p *Plugin) generateServiceMethod(pkgName, srvName string, md *descriptor.MethodDescriptorProto) {
-	interfaceName := srvName + "Server"
+// generateClient generates a typed client calling the service through *jsonrpc.RPCClient,
+// client-streaming methods are left out since they are only served over websocket
+func (p *Plugin) generateClient(pkgName, srvName string, sd *descriptor.ServiceDescriptorProto) {
+	interfaceName := srvName + "JSONRpcClient"
+	clientType := fmt.Sprintf("_jsonrpc_%s_Client", srvName)
+
+	allUnary := true
+	for _, md := range sd.GetMethod() {
+		if md.GetClientStreaming() || md.GetServerStreaming() {
+			allUnary = false
+		}
+	}
+
+	p.P("// ", interfaceName, " is the client API for ", srvName, " service over jsonrpc,")
+	p.P("// unary methods share the signatures of ", srvName, "Server")
+	p.P("type ", interfaceName, " interface {")
+	for _, md := range sd.GetMethod() {
+		if md.GetClientStreaming() {
+			continue
+		}
+
+		p.P(p.clientMethodSignature(pkgName, srvName, md))
+	}
+	p.P("}")
+	p.P()
+
+	p.P("// returns a ", interfaceName, " calling methods under JSONRpcAPIPrefixFor", srvName, "Server")
+	p.P(fmt.Sprintf("func NewJSONRpcClientFor%s(cli *%s.RPCClient) %s {", srvName, p.jsonrpcPkg, interfaceName))
+	p.P(fmt.Sprintf("return &%s{cli: cli}", clientType))
+	p.P("}")
+	p.P()
+
+	if allUnary {
+		p.P(fmt.Sprintf("var _ %sServer = (*%s)(nil)", srvName, clientType))
+		p.P()
+	}
+
+	p.P(fmt.Sprintf("type %s struct {", clientType))
+	p.P(fmt.Sprintf("cli *%s.RPCClient", p.jsonrpcPkg))
+	p.P("}")
+	p.P()
+
+	for _, md := range sd.GetMethod() {
+		if md.GetClientStreaming() {
+			continue
+		}
+
+		methodName := generator.CamelCase(md.GetName())
+		path := fmt.Sprintf("JSONRpcAPIPrefixFor%sServer+\"/%s\"", srvName, methodName)
+
+		outputType, err := actualTypeString(pkgName, generator.CamelCase(md.GetOutputType()))
+		if err != nil {
+			p.Error(err, "err captured during generating client for ", pkgName+".", srvName+".", methodName)
+		}
+
+		// methods declared with the standard idempotency_level option are retried by the RetryPolicy of the client
+		callOpts := ""
+		if md.GetOptions().GetIdempotencyLevel() != descriptor.MethodOptions_IDEMPOTENCY_UNKNOWN {
+			callOpts = fmt.Sprintf(", %s.Idempotent()", p.jsonrpcPkg)
+		}
+
+		p.P(fmt.Sprintf("func (c *%s) %s {", clientType, p.clientMethodSignature(pkgName, srvName, md)))
+
+		if md.GetServerStreaming() {
+			streamType := fmt.Sprintf("%s_%sJSONRpcStream", srvName, methodName)
+
+			p.P(fmt.Sprintf("reader, err := c.cli.Stream(ctx, %s, in%s)", path, callOpts))
+			p.P("if err != nil { return nil, err }")
+			p.P()
+			p.P(fmt.Sprintf("return &%s{reader: reader}, nil", streamType))
+			p.P("}")
+			p.P()
+
+			p.P("// ", streamType, " receives messages of ", srvName, ".", methodName, ", and should be closed after use")
+			p.P(fmt.Sprintf("type %s struct {", streamType))
+			p.P(fmt.Sprintf("reader *%s.StreamReader", p.jsonrpcPkg))
+			p.P("}")
+			p.P()
+			p.P("// Recv returns the next message, or io.EOF when the stream ends")
+			p.P(fmt.Sprintf("func (x *%s) Recv() (*%s, error) {", streamType, outputType))
+			p.P(fmt.Sprintf("m := new(%s)", outputType))
+			p.P("if err := x.reader.Recv(m); err != nil { return nil, err }")
+			p.P("return m, nil")
+			p.P("}")
+			p.P()
+			p.P("// Close releases the underlying connection")
+			p.P(fmt.Sprintf("func (x *%s) Close() error {", streamType))
+			p.P("return x.reader.Close()")
+			p.P("}")
+			p.P()
+			continue
+		}
+
+		p.P(fmt.Sprintf("out := new(%s)", outputType))
+		p.P(fmt.Sprintf("if err := c.cli.Call(ctx, %s, in, out%s); err != nil { return nil, err }", path, callOpts))
+		p.P("return out, nil")
+		p.P("}")
+		p.P()
+	}
+}
+
+func (p *Plugin) clientMethodSignature(pkgName, srvName string, md *descriptor.MethodDescriptorProto) string {
 	methodName := generator.CamelCase(md.GetName())
 
+	inputType, err := actualTypeString(pkgName, generator.CamelCase(md.GetInputType()))
+	if err != nil {
+		p.Error(err, "err captured during generating client for ", pkgName+".", srvName+".", methodName)
+	}
+
+	outputType, err := actualTypeString(pkgName, generator.CamelCase(md.GetOutputType()))
+	if err != nil {
+		p.Error(err, "err captured during generating client for ", pkgName+".", srvName+".", methodName)
+	}
+
+	if md.GetServerStreaming() {
+		outputType = fmt.Sprintf("%s_%sJSONRpcStream", srvName, methodName)
+	}
+
+	return fmt.Sprintf("%s(ctx %s.Context, in *%s) (*%s, error)", methodName, p.contextPkg, inputType, outputType)
+}
+
+// methodGrant returns the access scope & the required perm declared by method options, perm defaults to READ
+func methodGrant(md *descriptor.MethodDescriptorProto) (string, common.Perm) {
 	var grantScope string
 	var grantPerm = common.Perm_READ
 
@@ -148,6 +298,27 @@ func (p *Plugin) generateServiceMethod(pkgName, srvName string, md *descriptor.M
 		}
 	}
 
+	return grantScope, grantPerm
+}
+
+// methodCacheControl returns the cache control declared by method options, nil if not declared
+func methodCacheControl(md *descriptor.MethodDescriptorProto) *options.CacheControl {
+	opts := md.GetOptions()
+	if opts == nil {
+		return nil
+	}
+
+	ext, _ := proto.GetExtension(opts, options.E_CacheControl)
+	cc, _ := ext.(*options.CacheControl)
+	return cc
+}
+
+func (p *Plugin) generateServiceMethod(pkgName, srvName string, md *descriptor.MethodDescriptorProto) {
+	interfaceName := srvName + "Server"
+	methodName := generator.CamelCase(md.GetName())
+
+	grantScope, grantPerm := methodGrant(md)
+
 	p.P(fmt.Sprintf("func %s(srv %s) %s.HandlerFunc {", jsonrpcMethodHandlerName(srvName, methodName), interfaceName, p.jsonrpcPkg))
 	p.P()
 	p.P(fmt.Sprintf("return func(rw %s.ResponseWriter, req *%s.Request) error {", p.httpPkg, p.httpPkg))
@@ -158,11 +329,26 @@ func (p *Plugin) generateServiceMethod(pkgName, srvName string, md *descriptor.M
 		p.P()
 	}
 
+	// client-streaming & bidi-streaming methods receive their messages from the stream
+	if md.GetClientStreaming() {
+		p.P(fmt.Sprintf("req = %s.InjectHTTPRequest(req)", p.jsonrpcPkg))
+		p.P(fmt.Sprintf("stream, err := %s.NewDuplexStream(rw, req)", p.jsonrpcPkg))
+		p.P("if err != nil { return err }")
+		p.P()
+		p.P(fmt.Sprintf("return stream.Finish(srv.%s(&%s{stream}))", methodName, jsonrpcMethodStreamName(srvName, methodName)))
+		p.P("}")
+		p.P("}")
+		p.P()
+
+		p.generateMethodStream(pkgName, srvName, md)
+		return
+	}
+
 	inputType := generator.CamelCase(md.GetInputType())
 	if inputType == commonEmptyType {
 		p.P("input := common.EMPTY")
 	} else {
-		actualType, err := actualInputTypeString(pkgName, inputType)
+		actualType, err := actualTypeString(pkgName, inputType)
 		if err != nil {
 			p.Error(err, "err captured during generating handler for ", pkgName+".", srvName+".", methodName)
 		}
@@ -173,16 +359,73 @@ func (p *Plugin) generateServiceMethod(pkgName, srvName string, md *descriptor.M
 	p.P()
 
 	p.P(fmt.Sprintf("req = %s.InjectHTTPRequest(req)", p.jsonrpcPkg))
+
+	if md.GetServerStreaming() {
+		streamType := jsonrpcMethodStreamName(srvName, methodName)
+
+		p.P(fmt.Sprintf("stream := %s.NewServerStream(rw, req)", p.jsonrpcPkg))
+		p.P(fmt.Sprintf("return stream.Finish(srv.%s(input, &%s{stream}))", methodName, streamType))
+		p.P("}")
+		p.P("}")
+		p.P()
+
+		p.generateMethodStream(pkgName, srvName, md)
+		return
+	}
+
 	p.P(fmt.Sprintf("out, err := srv.%s(req.Context(), input)", methodName))
 	p.P("if err != nil { return err }")
 	p.P()
 
-	p.P(fmt.Sprintf("return %s.EncodeResponse(rw, out)", p.jsonrpcPkg))
+	p.P(fmt.Sprintf("return %s.EncodeResponseFor(rw, req, out)", p.jsonrpcPkg))
 	p.P("}")
 	p.P("}")
 	p.P()
 }
 
+// generateMethodStream generates the type implementing <Service>_<Method>Server on top of *jsonrpc.ServerStream
+func (p *Plugin) generateMethodStream(pkgName, srvName string, md *descriptor.MethodDescriptorProto) {
+	methodName := generator.CamelCase(md.GetName())
+	streamType := jsonrpcMethodStreamName(srvName, methodName)
+
+	outputType, err := actualTypeString(pkgName, generator.CamelCase(md.GetOutputType()))
+	if err != nil {
+		p.Error(err, "err captured during generating stream for ", pkgName+".", srvName+".", methodName)
+	}
+
+	p.P(fmt.Sprintf("// %s implements %s_%sServer over http", streamType, srvName, methodName))
+	p.P(fmt.Sprintf("type %s struct {", streamType))
+	p.P(fmt.Sprintf("*%s.ServerStream", p.jsonrpcPkg))
+	p.P("}")
+	p.P()
+
+	sendMethod := "Send"
+	if !md.GetServerStreaming() {
+		sendMethod = "SendAndClose"
+	}
+
+	p.P(fmt.Sprintf("func (x *%s) %s(m *%s) error {", streamType, sendMethod, outputType))
+	p.P("return x.ServerStream.SendMsg(m)")
+	p.P("}")
+	p.P()
+
+	if !md.GetClientStreaming() {
+		return
+	}
+
+	inputType, err := actualTypeString(pkgName, generator.CamelCase(md.GetInputType()))
+	if err != nil {
+		p.Error(err, "err captured during generating stream for ", pkgName+".", srvName+".", methodName)
+	}
+
+	p.P(fmt.Sprintf("func (x *%s) Recv() (*%s, error) {", streamType, inputType))
+	p.P(fmt.Sprintf("m := new(%s)", inputType))
+	p.P("if err := x.ServerStream.RecvMsg(m); err != nil { return nil, err }")
+	p.P("return m, nil")
+	p.P("}")
+	p.P()
+}
+
 // GenerateImports generates import statements
 func (p *Plugin) GenerateImports(fd *generator.FileDescriptor) {
 
@@ -192,11 +435,15 @@ func jsonrpcMethodHandlerName(srvName, methodName string) string {
 	return fmt.Sprintf("_jsonrpc_%s_%s_Handler", srvName, methodName)
 }
 
+func jsonrpcMethodStreamName(srvName, methodName string) string {
+	return fmt.Sprintf("_jsonrpc_%s_%s_Stream", srvName, methodName)
+}
+
 func trimLeftDots(s string) string {
 	return strings.TrimLeft(s, ".")
 }
 
-func actualInputTypeString(pkgName, raw string) (string, error) {
+func actualTypeString(pkgName, raw string) (string, error) {
 	pieces := strings.Split(raw, ".")
 	if len(pieces) == 0 || len(pieces) != 3 {
 		return "", fmt.Errorf("unexpected input type format, raw=%s", raw)
@@ -0,0 +1,318 @@
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// paths of the health endpoints
+const (
+	HealthPath = "/_health"
+	ReadyPath  = "/_ready"
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+
+	defaultCheckTimeout = 3 * time.Second
+)
+
+// Checker checks a dependency of the process, e.g. a database, and returns nil if it works
+type Checker func(ctx context.Context) error
+
+// CheckOption configures a registered checker
+type CheckOption func(*check)
+
+// CheckTimeout sets the timeout of each run of the checker, defaults to 3s
+func CheckTimeout(d time.Duration) CheckOption {
+	return func(c *check) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// CheckCacheTTL caches the result of the checker for the given duration, so that frequent probes
+// do not hit the dependency each time, zero means no cache
+func CheckCacheTTL(d time.Duration) CheckOption {
+	return func(c *check) {
+		c.ttl = d
+	}
+}
+
+// ForLiveness makes the checker count for liveness as well, checkers count for readiness only by default.
+// Failures of liveness checkers usually lead to restarts, thus only for those unrecoverable without one
+func ForLiveness() CheckOption {
+	return func(c *check) {
+		c.liveness = true
+	}
+}
+
+type check struct {
+	name     string
+	checker  Checker
+	timeout  time.Duration
+	ttl      time.Duration
+	liveness bool
+
+	// mu serializes runs of the checker, concurrent probes share the cached result
+	mu     sync.Mutex
+	result checkResult
+	at     time.Time
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+func (c *check) run(ctx context.Context) checkResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl > 0 && !c.at.IsZero() && time.Since(c.at) < c.ttl {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				errCh <- fmt.Errorf("checker panic: %v", e)
+			}
+		}()
+
+		errCh <- c.checker(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := checkResult{
+		Status:   statusOK,
+		Duration: time.Since(start).String(),
+	}
+
+	if err != nil {
+		res.Status = statusFail
+		res.Error = err.Error()
+	}
+
+	c.result, c.at = res, time.Now()
+	return res
+}
+
+// Health is a registry of named checkers serving liveness & readiness probes
+type Health struct {
+	mu       sync.RWMutex
+	parent   *Health
+	checks   map[string]*check
+	notReady string
+}
+
+// NewHealth returns an empty *Health
+func NewHealth() *Health {
+	return &Health{
+		checks: map[string]*check{},
+	}
+}
+
+// DefaultHealth is the registry used by RegisterChecker & RegisterHealthHandlers
+var DefaultHealth = NewHealth()
+
+// Child returns an empty *Health running the checkers of h as well, with its own not-ready switch,
+// e.g. for one of several servers in the process
+func (h *Health) Child() *Health {
+	c := NewHealth()
+	c.parent = h
+	return c
+}
+
+// Register adds a named checker, replacing any previous one with the same name
+func (h *Health) Register(name string, checker Checker, opts ...CheckOption) {
+	c := &check{
+		name:    name,
+		checker: checker,
+		timeout: defaultCheckTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	h.mu.Lock()
+	h.checks[name] = c
+	h.mu.Unlock()
+}
+
+// Unregister removes the named checker
+func (h *Health) Unregister(name string) {
+	h.mu.Lock()
+	delete(h.checks, name)
+	h.mu.Unlock()
+}
+
+// SetNotReady makes readiness probes fail with the given reason regardless of checkers, e.g. during drain
+func (h *Health) SetNotReady(reason string) {
+	if reason == "" {
+		reason = "not ready"
+	}
+
+	h.mu.Lock()
+	h.notReady = reason
+	h.mu.Unlock()
+}
+
+// SetReady reverts SetNotReady
+func (h *Health) SetReady() {
+	h.mu.Lock()
+	h.notReady = ""
+	h.mu.Unlock()
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Reason string                 `json:"reason,omitempty"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// check runs checkers concurrently, only those for liveness if liveness is true,
+// and reports whether all of them pass along with the detailed report
+func (h *Health) check(ctx context.Context, liveness bool) (bool, healthReport) {
+	byName := map[string]*check{}
+	reason := h.collect(byName)
+
+	checks := make([]*check, 0, len(byName))
+	for _, c := range byName {
+		if !liveness || c.liveness {
+			checks = append(checks, c)
+		}
+	}
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+
+	results := make([]checkResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = checks[i].run(ctx)
+		}(i)
+	}
+
+	wg.Wait()
+
+	report := healthReport{
+		Status: statusOK,
+		Checks: map[string]checkResult{},
+	}
+
+	if !liveness && reason != "" {
+		report.Status = statusFail
+		report.Reason = reason
+	}
+
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != statusOK {
+			report.Status = statusFail
+		}
+	}
+
+	return report.Status == statusOK, report
+}
+
+// collect adds the checkers of h & its ancestors to checks, those of h replacing the inherited ones,
+// and returns the not-ready reason of h, or of its ancestors if h is ready
+func (h *Health) collect(checks map[string]*check) string {
+	var reason string
+	if h.parent != nil {
+		reason = h.parent.collect(checks)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for name, c := range h.checks {
+		checks[name] = c
+	}
+
+	if h.notReady != "" {
+		reason = h.notReady
+	}
+
+	return reason
+}
+
+// Live reports whether all liveness checkers pass
+func (h *Health) Live(ctx context.Context) bool {
+	ok, _ := h.check(ctx, true)
+	return ok
+}
+
+// Ready reports whether the process is not marked as not ready and all checkers pass
+func (h *Health) Ready(ctx context.Context) bool {
+	ok, _ := h.check(ctx, false)
+	return ok
+}
+
+// LivenessHandler serves liveness probes, replying 200 or 503 with a json report
+func (h *Health) LivenessHandler() http.Handler {
+	return h.handler(true)
+}
+
+// ReadinessHandler serves readiness probes, replying 200 or 503 with a json report
+func (h *Health) ReadinessHandler() http.Handler {
+	return h.handler(false)
+}
+
+func (h *Health) handler(liveness bool) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ok, report := h.check(req.Context(), liveness)
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-cache")
+
+		if ok {
+			rw.WriteHeader(http.StatusOK)
+		} else {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(rw).Encode(report)
+	})
+}
+
+// RegisterChecker adds a named checker to DefaultHealth
+func RegisterChecker(name string, checker Checker, opts ...CheckOption) {
+	DefaultHealth.Register(name, checker, opts...)
+}
+
+// RegisterHealthHandlers 注册 health & ready handler
+func RegisterHealthHandlers(mux *http.ServeMux, h *Health) {
+	if h == nil {
+		h = DefaultHealth
+	}
+
+	mux.Handle(HealthPath, h.LivenessHandler())
+	mux.Handle(ReadyPath, h.ReadinessHandler())
+}
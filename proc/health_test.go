@@ -0,0 +1,98 @@
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	h := NewHealth()
+
+	var dbCalls int32
+	dbErr := errors.New("connection refused")
+	h.Register("db", func(ctx context.Context) error {
+		atomic.AddInt32(&dbCalls, 1)
+		return dbErr
+	}, CheckCacheTTL(time.Minute))
+
+	h.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, CheckTimeout(10*time.Millisecond), ForLiveness())
+
+	h.Register("loop", func(ctx context.Context) error { return nil }, ForLiveness())
+
+	probe := func(hdl http.Handler) (int, healthReport) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		report := healthReport{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+
+		return rec.Code, report
+	}
+
+	code, report := probe(h.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || report.Checks["db"].Error != dbErr.Error() || report.Checks["slow"].Status != statusFail {
+		t.Fatalf("unexpected readiness %d %+v", code, report)
+	}
+
+	probe(h.ReadinessHandler())
+	if n := atomic.LoadInt32(&dbCalls); n != 1 {
+		t.Fatalf("expected cached result, checker called %d times", n)
+	}
+
+	h.Unregister("slow")
+	code, report = probe(h.LivenessHandler())
+	if code != http.StatusOK || len(report.Checks) != 1 {
+		t.Fatalf("unexpected liveness %d %+v", code, report)
+	}
+
+	h.Unregister("db")
+	if !h.Ready(context.Background()) {
+		t.Fatal("expected ready")
+	}
+
+	h.SetNotReady("draining")
+	code, report = probe(h.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || report.Reason != "draining" {
+		t.Fatalf("unexpected readiness %d %+v", code, report)
+	}
+
+	if !h.Live(context.Background()) {
+		t.Fatal("expected live while not ready")
+	}
+
+	h.SetReady()
+	if !h.Ready(context.Background()) {
+		t.Fatal("expected ready")
+	}
+}
+
+func TestHealthChild(t *testing.T) {
+	parent := NewHealth()
+	a, b := parent.Child(), parent.Child()
+
+	a.SetNotReady("draining")
+	if a.Ready(context.Background()) || !b.Ready(context.Background()) || !parent.Ready(context.Background()) {
+		t.Fatal("expected the not-ready switch of a child not shared")
+	}
+
+	parent.Register("db", func(ctx context.Context) error { return errors.New("down") })
+	if b.Ready(context.Background()) {
+		t.Fatal("expected checkers of the parent run by children")
+	}
+
+	b.Register("db", func(ctx context.Context) error { return nil })
+	if !b.Ready(context.Background()) {
+		t.Fatal("expected checkers of the child replacing those of the parent")
+	}
+}
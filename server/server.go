@@ -0,0 +1,347 @@
+// Package server provides a http server running a jsonrpc mux with graceful shutdown
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ipfs-force-community/gosf/jsonrpc"
+	"github.com/ipfs-force-community/gosf/logger"
+	"github.com/ipfs-force-community/gosf/metric"
+	"github.com/ipfs-force-community/gosf/proc"
+)
+
+// DefaultDrainPeriod is how long in-flight requests are waited for during shutdown by default
+const DefaultDrainPeriod = 15 * time.Second
+
+// Option configures a *Server
+type Option func(*Server)
+
+// ListenTCP adds a tcp listener on the given address, e.g. ":8080"
+func ListenTCP(addr string) Option {
+	return func(s *Server) {
+		s.listens = append(s.listens, func() (net.Listener, error) {
+			return net.Listen("tcp", addr)
+		})
+	}
+}
+
+// ListenUnix adds a unix socket listener on the given path, a stale socket file left by a previous process is removed,
+// while a socket still accepting connections fails the listen
+func ListenUnix(path string) Option {
+	return func(s *Server) {
+		s.listens = append(s.listens, func() (net.Listener, error) {
+			if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				conn, err := net.Dial("unix", path)
+				if err == nil {
+					conn.Close()
+					return nil, fmt.Errorf("unix socket %s in use by a running process", path)
+				}
+
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					os.Remove(path)
+				}
+			}
+
+			return net.Listen("unix", path)
+		})
+	}
+}
+
+// ListenTLS adds a tls listener on the given address
+func ListenTLS(addr string, cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.listens = append(s.listens, func() (net.Listener, error) {
+			return tls.Listen("tcp", addr, cfg)
+		})
+	}
+}
+
+// WithListener adds an opened listener, which is closed by the server
+func WithListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.listens = append(s.listens, func() (net.Listener, error) {
+			return l, nil
+		})
+	}
+}
+
+// WithDrainPeriod sets how long in-flight requests are waited for after the server stops accepting new ones,
+// requests still running after the period are canceled through their contexts and the connections are closed
+func WithDrainPeriod(d time.Duration) Option {
+	return func(s *Server) {
+		if d > 0 {
+			s.drainPeriod = d
+		}
+	}
+}
+
+// WithSignals sets the signals triggering a graceful shutdown, defaults to SIGTERM & SIGINT
+func WithSignals(sigs ...os.Signal) Option {
+	return func(s *Server) {
+		s.signals = sigs
+	}
+}
+
+// WithHealth sets the registry serving /_health & /_ready, defaults to a child of proc.DefaultHealth owned by the server,
+// so that draining one server does not mark others in the process as not ready
+func WithHealth(h *proc.Health) Option {
+	return func(s *Server) {
+		if h != nil {
+			s.health = h
+		}
+	}
+}
+
+// WithShutdownDelay sets how long the server keeps accepting requests after being marked as not ready,
+// before it stops accepting new ones, so that load balancers probing /_ready deregister it in time
+func WithShutdownDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownDelay = d
+	}
+}
+
+// WithMetricPush runs metric.Run with the given config while the server is running, and flushes it on exit
+func WithMetricPush(cfg metric.PushConfig) Option {
+	return func(s *Server) {
+		s.metricCfg = &cfg
+	}
+}
+
+// WithHTTPServer uses the given *http.Server as a template for timeouts, limits & error logging,
+// its Handler & BaseContext are replaced
+func WithHTTPServer(hs *http.Server) Option {
+	return func(s *Server) {
+		s.hs = hs
+	}
+}
+
+// New returns a *Server serving the given mux, along with /_version, /_health & /_ready
+func New(jmux *jsonrpc.Mux, opts ...Option) *Server {
+	s := &Server{
+		stdmux:      http.NewServeMux(),
+		health:      proc.DefaultHealth.Child(),
+		drainPeriod: DefaultDrainPeriod,
+		signals:     []os.Signal{syscall.SIGTERM, syscall.SIGINT},
+		hs:          &http.Server{},
+		shutdown:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	jsonrpc.RegisterMux(s.stdmux, jmux)
+	proc.RegisterHealthHandlers(s.stdmux, s.health)
+
+	return s
+}
+
+// Server runs a jsonrpc mux on one or more listeners.
+//
+// Run blocks until the given context is done, one of the signals is received, Shutdown is called,
+// or any listener fails. The server then marks itself as not ready, waits for the shutdown delay,
+// stops accepting new connections, waits for in-flight requests up to the drain period,
+// and flushes metrics & the logger before Run returns.
+type Server struct {
+	stdmux        *http.ServeMux
+	listens       []func() (net.Listener, error)
+	health        *proc.Health
+	drainPeriod   time.Duration
+	shutdownDelay time.Duration
+	signals       []os.Signal
+	metricCfg     *metric.PushConfig
+	hs            *http.Server
+
+	// inflight tracks running handlers, including those of hijacked connections, e.g. websockets,
+	// which are not waited for by http.Server.Shutdown
+	inflight inflightTracker
+
+	shutdownOnce sync.Once
+	shutdown     chan struct{}
+}
+
+// Handle registers an extra handler, e.g. the json-rpc 2.0 or the websocket endpoint of the mux
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.stdmux.Handle(pattern, h)
+}
+
+// Handler returns the root handler of the server
+func (s *Server) Handler() http.Handler {
+	return s.stdmux
+}
+
+// Shutdown triggers a graceful shutdown of a running server, Run returns after it completes
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
+}
+
+// Run opens all listeners and serves until shutdown
+func (s *Server) Run(ctx context.Context) error {
+	if len(s.listens) == 0 {
+		return fmt.Errorf("no listener configured")
+	}
+
+	listeners := make([]net.Listener, 0, len(s.listens))
+	for _, listen := range s.listens {
+		l, err := listen()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+
+			return fmt.Errorf("unable to listen, err=%v", err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	// contexts of requests are canceled when the drain period expires, e.g. for long-lived streams
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	hs := s.hs
+	hs.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.inflight.add()
+		defer s.inflight.done()
+
+		s.stdmux.ServeHTTP(rw, req)
+	})
+	hs.BaseContext = func(net.Listener) context.Context {
+		return baseCtx
+	}
+
+	var metricDone chan struct{}
+	metricCtx, cancelMetric := context.WithCancel(context.Background())
+	defer cancelMetric()
+
+	if s.metricCfg != nil {
+		metricDone = make(chan struct{})
+		go func() {
+			defer close(metricDone)
+			metric.Run(metricCtx, *s.metricCfg)
+		}()
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		logger.LS().Infof("server listening, addr=%s://%s", l.Addr().Network(), l.Addr().String())
+
+		go func(l net.Listener) {
+			errCh <- hs.Serve(l)
+		}(l)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	if len(s.signals) > 0 {
+		signal.Notify(sigCh, s.signals...)
+		defer signal.Stop(sigCh)
+	}
+
+	var runErr error
+
+	select {
+	case <-ctx.Done():
+		logger.LS().Info("server context done, shutting down")
+
+	case sig := <-sigCh:
+		logger.LS().Infof("signal %s received, shutting down", sig)
+
+	case <-s.shutdown:
+		logger.LS().Info("server shutdown requested")
+
+	case err := <-errCh:
+		logger.LS().Errorf("server stopped unexpectedly, shutting down, err=%v", err)
+		runErr = err
+	}
+
+	s.health.SetNotReady("shutting down")
+	if s.shutdownDelay > 0 && runErr == nil {
+		time.Sleep(s.shutdownDelay)
+	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), s.drainPeriod)
+	defer cancelDrain()
+
+	err := hs.Shutdown(drainCtx)
+	if err == nil {
+		err = s.inflight.wait(drainCtx)
+	}
+
+	if err != nil {
+		logger.LS().Warnf("in-flight requests not finished in %s, closing, err=%v", s.drainPeriod, err)
+		cancelBase()
+		hs.Close()
+	}
+
+	cancelMetric()
+	if metricDone != nil {
+		<-metricDone
+	}
+
+	logger.LS().Info("server stopped")
+	logger.LS().Sync()
+
+	return runErr
+}
+
+// inflightTracker counts running handlers, and can be waited for with a context, unlike sync.WaitGroup
+type inflightTracker struct {
+	mu sync.Mutex
+	n  int
+
+	// idle is closed when n drops to zero, created by waiters
+	idle chan struct{}
+}
+
+func (t *inflightTracker) add() {
+	t.mu.Lock()
+	t.n++
+	t.mu.Unlock()
+}
+
+func (t *inflightTracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.n--
+	if t.n == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// wait blocks until no handler is running or ctx is done
+func (t *inflightTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
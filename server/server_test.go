@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs-force-community/gosf/jsonrpc"
+)
+
+func TestServerGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+
+	mux := jsonrpc.NewMux("", nil)
+	mux.Handle("/Slow", func(rw http.ResponseWriter, req *http.Request) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, err := rw.Write([]byte("done"))
+		return err
+	})
+
+	sock := filepath.Join(t.TempDir(), "server.sock")
+
+	srv := New(mux, ListenUnix(sock), WithDrainPeriod(time.Second), WithSignals())
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- srv.Run(context.Background())
+	}()
+
+	cli := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+
+	var resp *http.Response
+	respErr := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = cli.Post("http://unix/Slow", jsonrpc.ContentTypeJSON, nil)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		respErr <- err
+	}()
+
+	<-started
+	srv.Shutdown()
+
+	if err := <-respErr; err != nil {
+		t.Fatalf("in-flight request failed, err=%v", err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "done" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("run failed, err=%v", err)
+	}
+
+	if _, err := cli.Post("http://unix/Slow", jsonrpc.ContentTypeJSON, nil); err == nil {
+		t.Fatal("expected requests to be rejected after shutdown")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "server.sock")
+
+	live, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(jsonrpc.NewMux("", nil), ListenUnix(sock))
+	if _, err := srv.listens[0](); err == nil {
+		t.Fatal("expected the socket of a running process not taken over")
+	}
+
+	// a socket file left by a dead process refuses connections
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	live.Close()
+	if _, err := os.Stat(sock); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := srv.listens[0]()
+	if err != nil {
+		t.Fatalf("expected the stale socket removed, err=%v", err)
+	}
+
+	l.Close()
+}
+
+func TestServerHealthNotShared(t *testing.T) {
+	a, b := New(jsonrpc.NewMux("", nil)), New(jsonrpc.NewMux("", nil))
+
+	a.health.SetNotReady("draining")
+	if !b.health.Ready(context.Background()) {
+		t.Fatal("expected draining one server not affecting the other")
+	}
+}
+
+func TestInflightTrackerWait(t *testing.T) {
+	var tracker inflightTracker
+	tracker.add()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := tracker.wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait timed out, err=%v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tracker.done()
+	}()
+
+	if err := tracker.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}